@@ -20,5 +20,6 @@ case "${KAD_BOOTSTRAP:-}" in
   1|true|TRUE|yes|YES) set -- "$@" --bootstrap ;;
 esac
 [ -n "${KAD_PEERS:-}" ]     && set -- "$@" --peers     "$KAD_PEERS"
+[ -n "${KAD_DATA_DIR:-}" ]  && set -- "$@" --data-dir  "$KAD_DATA_DIR"
 
 exec "$@"
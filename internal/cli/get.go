@@ -1,46 +1,99 @@
 package cli
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
 	"fmt"
 	"os"
-	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
 	"github.com/t0sic/D7024E-Kademlia/internal/util"
 )
 
+var flagGetMutable bool
+
 var cmdGet = &cobra.Command{
-	Use:   "get <sha1-hex>",
-	Short: "Fetch bytes by SHA-1 content hash and print source node",
-	Args:  cobra.ExactArgs(1),
+	Use:   "get <key-hex>",
+	Short: "Fetch bytes by content hash and print source node",
+	Long: "Fetch bytes by content hash and print source node. The key is a hex\n" +
+		"ID as wide as the node's (SHA-1 for 160-bit IDs, SHA-256 for 256-bit).\n" +
+		"With --mutable the argument is a public key and the newest record\n" +
+		"signed by it is fetched.",
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		n := getNode(cmd)
 		if n == nil {
 			return fmt.Errorf("no running node in context; start with 'run'")
 		}
 
+		if flagGetMutable {
+			return getMutable(cmd.Context(), n, args[0])
+		}
+
 		keyHex := args[0]
 		keyID, err := util.ParseHexID(keyHex)
 		if err != nil {
 			return fmt.Errorf("invalid hash: %w", err)
 		}
+		if keyID.Len() != n.ID.Len() {
+			return fmt.Errorf("invalid hash: %d bits, node IDs are %d", keyID.Len()*8, n.ID.Len()*8)
+		}
 
-		val, from, err := n.IterativeFindValue(keyID, 800*time.Millisecond)
+		res, err := n.Get(cmd.Context(), keyID, node.ADAPTIVE_TIMEOUT)
+		for _, c := range res.Rejected {
+			fmt.Printf("rejected: %s (content does not match hash)\n", c.String())
+		}
 		if err != nil {
 			return err
 		}
+		val := res.Value
 
 		fmt.Printf("hash: %s\n", keyHex)
-		if from == nil {
+		if res.From == nil {
 			fmt.Printf("node: local (%s)\n", n.ID.String())
 		} else {
-			fmt.Printf("node: %s\n", from.String())
-		}
-		fmt.Println("--- content ---")
-		os.Stdout.Write(val)
-		if len(val) == 0 || val[len(val)-1] != '\n' {
-			fmt.Println()
+			fmt.Printf("node: %s\n", res.From.String())
 		}
+		printContent(val)
 		return nil
 	},
 }
+
+func init() {
+	cmdGet.Flags().BoolVar(&flagGetMutable, "mutable", false, "fetch the newest record signed by a public key")
+}
+
+func getMutable(ctx context.Context, n *node.Node, pubHex string) error {
+	pub, err := hex.DecodeString(pubHex)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key %q", pubHex)
+	}
+
+	res, err := n.GetMutable(ctx, pub, node.ADAPTIVE_TIMEOUT)
+	for _, c := range res.Rejected {
+		fmt.Printf("rejected: %s (bad signature)\n", c.String())
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("key: %s\n", pubHex)
+	fmt.Printf("seq: %d\n", res.Record.Seq)
+	if res.From == nil {
+		fmt.Printf("node: local (%s)\n", n.ID.String())
+	} else {
+		fmt.Printf("node: %s\n", res.From.String())
+	}
+	printContent(res.Record.Value)
+	return nil
+}
+
+func printContent(val []byte) {
+	fmt.Println("--- content ---")
+	os.Stdout.Write(val)
+	if len(val) == 0 || val[len(val)-1] != '\n' {
+		fmt.Println()
+	}
+}
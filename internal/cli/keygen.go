@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var flagKeyOut string
+
+var cmdKeygen = &cobra.Command{
+	Use:   "keygen",
+	Short: "Create an ed25519 key for mutable records and print its public key",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+
+		// O_EXCL: never clobber an existing key, its records would be orphaned
+		f, err := os.OpenFile(flagKeyOut, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := fmt.Fprintln(f, hex.EncodeToString(priv.Seed())); err != nil {
+			return err
+		}
+
+		fmt.Printf("key: %s\n", flagKeyOut)
+		fmt.Printf("%x\n", pub)
+		return nil
+	},
+}
+
+func init() {
+	cmdKeygen.Flags().StringVar(&flagKeyOut, "out", "kad.key", "file to write the private key to")
+}
+
+// readKeyFile loads a private key written by keygen
+func readKeyFile(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s is not a key written by keygen", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
@@ -10,10 +10,18 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	flagPutMutable bool
+	flagPutKey     string
+)
+
 var cmdPut = &cobra.Command{
 	Use:   "put <data|@/path/to/file|->",
-	Short: "Store bytes and print their SHA-1 content hash",
-	Args:  cobra.ExactArgs(1),
+	Short: "Store bytes and print their content hash",
+	Long: "Store bytes and print their content hash, SHA-1 or SHA-256 by ID width.\n" +
+		"With --mutable --key <file> the bytes are signed with the key and stored\n" +
+		"under it, replacing any earlier value; the public key is printed instead.",
+	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		n := getNode(cmd)
 		if n == nil {
@@ -26,17 +34,40 @@ var cmdPut = &cobra.Command{
 			return err
 		}
 
-		hash, err := n.Put(payload)
+		if flagPutMutable {
+			if flagPutKey == "" {
+				return fmt.Errorf("--mutable needs --key <file>")
+			}
+			priv, err := readKeyFile(flagPutKey)
+			if err != nil {
+				return err
+			}
+			rec, err := n.PutMutable(cmd.Context(), priv, payload)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("seq: %d\n", rec.Seq)
+			fmt.Printf("%x\n", rec.PublicKey)
+			return nil
+		}
+
+		res, err := n.Put(cmd.Context(), payload)
 		if err != nil {
 			return err
 		}
 
-		// Print ONLY the hex hash
-		fmt.Printf("%x\n", hash)
+		// Replica count first, the hex hash stays the last line
+		fmt.Printf("replicas: %d\n", len(res.Replicas))
+		fmt.Printf("%x\n", res.Key)
 		return nil
 	},
 }
 
+func init() {
+	cmdPut.Flags().BoolVar(&flagPutMutable, "mutable", false, "store a signed record that can be updated later")
+	cmdPut.Flags().StringVar(&flagPutKey, "key", "", "private key file from keygen (with --mutable)")
+}
+
 // readSingleArg supports:
 //
 //	put hello
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
 	"github.com/t0sic/D7024E-Kademlia/internal/node"
 	"github.com/t0sic/D7024E-Kademlia/internal/util"
 )
@@ -17,6 +18,17 @@ var (
 	flagIDSeed    string
 	flagBootstrap bool
 	flagPeersCSV  string
+	flagDataDir   string
+	flagWire      string
+	flagMaxStore  int64
+	flagEvict     string
+	flagQuorum    int
+	flagK         int
+	flagAlpha     int
+	flagRepl      int
+	flagIDBits    int
+	flagTable     string
+	flagRelaxed   bool
 
 	rootCmd = &cobra.Command{
 		Use:   "kad",
@@ -40,31 +52,59 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&flagIDSeed, "id-seed", "", "seed for ID generation (optional)")
 	rootCmd.PersistentFlags().BoolVar(&flagBootstrap, "bootstrap", false, "bootstrap to provided peers (optional)")
 	rootCmd.PersistentFlags().StringVar(&flagPeersCSV, "peers", "", "comma-separated list of bootstrap peers (optional)")
+	cmdRun.Flags().StringVar(&flagDataDir, "data-dir", "", "directory for persistent value storage (optional, default in-memory)")
+	cmdRun.Flags().StringVar(&flagWire, "wire", "binary", "wire format: binary (negotiated per peer) or text")
+	cmdRun.Flags().Int64Var(&flagMaxStore, "max-store-bytes", node.MAX_STORE_BYTES, "value bytes held before evicting")
+	cmdRun.Flags().StringVar(&flagEvict, "evict", node.EVICT_FURTHEST, "eviction policy when full: furthest (from own ID) or lru")
+	cmdRun.Flags().IntVar(&flagQuorum, "quorum", node.WRITE_QUORUM, "remote acks a put needs, capped at the nodes known; 0 for none")
+	cmdRun.Flags().IntVar(&flagK, "k", kademlia.K, "bucket size; must match the rest of the network")
+	cmdRun.Flags().IntVar(&flagAlpha, "alpha", kademlia.ALPHA, "lookup concurrency; must match the rest of the network")
+	cmdRun.Flags().IntVar(&flagRepl, "replication", kademlia.K, "nodes each value is stored on; must match the rest of the network")
+	cmdRun.Flags().StringVar(&flagTable, "table", kademlia.TABLE_FLAT, "routing table layout: flat (bucket per prefix length) or tree (split on demand)")
+	cmdRun.Flags().BoolVar(&flagRelaxed, "relaxed-split", false, "with --table tree, also split buckets away from our ID to keep all our K closest")
+	cmdRun.Flags().IntVar(&flagIDBits, "id-bits", util.IDBytes*8, "ID width, 160 (SHA-1 keys) or 256 (SHA-256 keys); must match the rest of the network")
 
 	rootCmd.AddCommand(cmdRun)
+	rootCmd.AddCommand(cmdKeygen)
 }
 
 func replRootCmd() *cobra.Command {
+	// The REPL reuses the same commands for every line, so flags from the
+	// previous line must not leak into the next one
+	flagPutMutable, flagPutKey, flagGetMutable = false, "", false
+	flagTraceJSON, flagTraceNode = false, false
+	flagKeyOut = "kad.key"
+
 	r := &cobra.Command{Use: "kad-repl"}
 	r.AddCommand(cmdExit)
 	r.AddCommand(cmdTest)
 	r.AddCommand(cmdPut)
 	r.AddCommand(cmdGet)
+	r.AddCommand(cmdKeygen)
+	r.AddCommand(cmdTable)
+	r.AddCommand(cmdTrace)
 	return r
 }
 
 func buildID() util.ID {
+	width := flagIDBits / 8
+	if flagIDBits%8 != 0 || !util.ValidIDBytes(width) {
+		log.Fatalf("invalid --id-bits %d: want %d or %d", flagIDBits, util.IDBytes*8, util.WIDE_ID_BYTES*8)
+	}
 	switch {
 	case strings.TrimSpace(flagIDHex) != "":
 		id, err := util.ParseHexID(flagIDHex)
 		if err != nil {
 			log.Fatalf("invalid --id: %v", err)
 		}
+		if id.Len() != width {
+			log.Fatalf("invalid --id: %d bits, --id-bits is %d", id.Len()*8, flagIDBits)
+		}
 		return id
 	case strings.TrimSpace(flagIDSeed) != "":
-		return util.NewIDFromSeed(flagIDSeed)
+		return util.NewIDFromSeedLen(flagIDSeed, width)
 	default:
-		return util.NewRandomID()
+		return util.NewRandomIDLen(width)
 	}
 }
 
@@ -81,12 +121,34 @@ func parsePeers() []string {
 	return peers
 }
 
+// writeQuorum maps --quorum to NodeConfig.WriteQuorum, where zero means
+// the default
+func writeQuorum() int {
+	if flagQuorum <= 0 {
+		return node.NO_WRITE_QUORUM
+	}
+	return flagQuorum
+}
+
 func newNode() *node.Node {
 	cfg := node.NodeConfig{
-		ID:        buildID(),
-		Addr:      flagAddr,
-		Bootstrap: flagBootstrap,
-		Peers:     parsePeers(),
+		ID:         buildID(),
+		Addr:       flagAddr,
+		Bootstrap:  flagBootstrap,
+		Peers:      parsePeers(),
+		DataDir:    flagDataDir,
+		WireFormat: flagWire,
+
+		MaxStoreBytes:  flagMaxStore,
+		EvictionPolicy: flagEvict,
+		WriteQuorum:    writeQuorum(),
+
+		K:           flagK,
+		Alpha:       flagAlpha,
+		Replication: flagRepl,
+
+		TableLayout:  flagTable,
+		RelaxedSplit: flagRelaxed,
 	}
 	return node.CreateNode(cfg)
 }
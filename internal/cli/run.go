@@ -36,15 +36,32 @@ var cmdRun = &cobra.Command{
 		// 2) Decide how to wait: REPL (interactive) or signals (headless)
 		isInteractive := term.IsTerminal(int(os.Stdin.Fd()))
 		if !isInteractive {
-			// Headless under Docker/Compose: block until SIGINT/SIGTERM
+			// Headless under Docker/Compose: block until SIGINT/SIGTERM,
+			// joining in the background if the peers are not up yet
 			sigc := make(chan os.Signal, 1)
 			signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
-			<-sigc
+			select {
+			case <-n.Ready():
+				fmt.Println("Node ready")
+				<-sigc
+			case <-sigc:
+			}
 			fmt.Println("Signal received; shutting down node…")
 			return nil
 		}
 
-		// 3) REPL path (interactive)
+		// 3) REPL path (interactive), once joined unless Ctrl-C skips the wait
+		select {
+		case <-n.Ready():
+		default:
+			fmt.Println("Waiting to join the network (Ctrl-C to skip)…")
+			waitCtx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			select {
+			case <-n.Ready():
+			case <-waitCtx.Done():
+			}
+			stop()
+		}
 		ctx := withNode(cmd.Context(), n)
 		fmt.Println("Interactive mode. Type 'help' or 'exit'.")
 		reader := bufio.NewReader(os.Stdin)
@@ -66,7 +83,11 @@ var cmdRun = &cobra.Command{
 			args := strings.Fields(line)
 			replRoot := replRootCmd() // root without "run"
 			replRoot.SetArgs(args)
-			if err := replRoot.ExecuteContext(ctx); err != nil {
+			// Ctrl-C aborts the command in flight rather than the REPL
+			cmdCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+			err = replRoot.ExecuteContext(cmdCtx)
+			stop()
+			if err != nil {
 				if errors.Is(err, errREPLExit) {
 					break
 				}
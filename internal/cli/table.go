@@ -0,0 +1,35 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var cmdTable = &cobra.Command{
+	Use:   "table",
+	Short: "Print the routing table with each contact's RTT and failures",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		n := getNode(cmd)
+		if n == nil {
+			return fmt.Errorf("no running node in context; start with 'run'")
+		}
+
+		contacts := n.RoutingTable.Contacts()
+		fmt.Printf("%d contacts\n", len(contacts))
+		for _, c := range contacts {
+			rtt, reply := "-", "never"
+			if srtt := n.SmoothedRTT(&c.Address); srtt > 0 {
+				rtt = srtt.Round(time.Microsecond).String()
+			}
+			if !c.LastReply.IsZero() {
+				reply = time.Since(c.LastReply).Round(time.Second).String() + " ago"
+			}
+			fmt.Printf("bucket %3d  %s  %-21s  rtt %-10s  failures %d  last reply %s\n",
+				n.ID.CommonPrefixLen(c.ID), c.ID.String(), c.Address.String(), rtt, c.Failures, reply)
+		}
+		return nil
+	},
+}
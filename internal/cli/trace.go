@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+var (
+	flagTraceJSON bool
+	flagTraceNode bool
+)
+
+var cmdTrace = &cobra.Command{
+	Use:   "trace <id>",
+	Short: "Look up a key and show every query the lookup made",
+	Long: "Run a FIND_VALUE lookup for the key and print each query: its round,\n" +
+		"the contact asked, its RTT and outcome, the contacts it returned and\n" +
+		"how close to the key the lookup had come. With --node a FIND_NODE\n" +
+		"lookup is traced instead; --json prints the trace as JSON.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		n := getNode(cmd)
+		if n == nil {
+			return fmt.Errorf("no running node in context; start with 'run'")
+		}
+		target, err := util.ParseHexID(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid id: %w", err)
+		}
+
+		trace := &node.Trace{}
+		ctx := node.WithTrace(cmd.Context(), trace)
+		var lookupErr error
+		if flagTraceNode {
+			n.IterativeFindNode(ctx, target, node.ADAPTIVE_TIMEOUT)
+		} else {
+			_, _, lookupErr = n.IterativeFindValue(ctx, target, node.ADAPTIVE_TIMEOUT)
+		}
+
+		if flagTraceJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(trace.Lookups()); err != nil {
+				return err
+			}
+		} else {
+			for _, lt := range trace.Lookups() {
+				printTrace(lt)
+			}
+		}
+		return lookupErr
+	},
+}
+
+func init() {
+	cmdTrace.Flags().BoolVar(&flagTraceJSON, "json", false, "print the trace as JSON")
+	cmdTrace.Flags().BoolVar(&flagTraceNode, "node", false, "trace a FIND_NODE lookup instead of FIND_VALUE")
+}
+
+// printTrace renders one lookup as a table, one row per query
+func printTrace(lt *node.LookupTrace) {
+	fmt.Printf("%s %s: %s after %s, %d queries\n",
+		lt.Kind, lt.Target, lt.Result, lt.Duration.Round(time.Microsecond), len(lt.Queries))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROUND\tCONTACT\tADDRESS\tPREFIX\tSENT\tRTT\tOUTCOME\tRETURNED\tLEARNED\tCLOSEST\tERROR")
+	for _, q := range lt.Queries {
+		rtt := "-"
+		if q.Outcome != node.TRACE_ABANDONED {
+			rtt = q.RTT.Round(time.Microsecond).String()
+		}
+		closest := "-"
+		if q.Closest >= 0 {
+			closest = fmt.Sprint(q.Closest)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			q.Round, q.ID, q.Addr, q.Prefix, q.Sent.Round(time.Microsecond), rtt,
+			q.Outcome, q.Returned, len(q.Learned), closest, q.Error)
+	}
+	w.Flush()
+
+	for _, c := range lt.Contacts {
+		fmt.Printf("closest: %s\n", c)
+	}
+}
@@ -2,26 +2,53 @@ package kademlia
 
 import (
 	"container/list"
+	"net"
+	"net/netip"
+	"time"
 
 	"github.com/t0sic/D7024E-Kademlia/internal/util"
 )
 
+// REPLACEMENT_CACHE_SIZE bounds how many candidates a full bucket remembers
+// in a table with the default K; every bucket keeps as many as it holds.
+const REPLACEMENT_CACHE_SIZE = K
+
 // bucket definition
-// contains a List
+// contains a List of up to k contacts, a replacement cache of candidates seen
+// while the bucket was full (most recent first) and when a contact or lookup
+// last touched its range. Both lists are indexed by ID, and the contacts by
+// address, so membership checks do not scan them.
 type Bucket struct {
-	list *list.List
+	k            int
+	list         *list.List
+	replacements *list.List
+	members      map[util.ID]*list.Element
+	addrs        map[netip.AddrPort]*list.Element
+	candidates   map[util.ID]*list.Element
+	lastTouched  time.Time
 }
 
-// newBucket returns a new instance of a bucket
-func newBucket() *Bucket {
-	bucket := &Bucket{}
+// newBucket returns a new instance of a bucket holding k contacts
+func newBucket(k int) *Bucket {
+	bucket := &Bucket{k: k}
 	bucket.list = list.New()
+	bucket.replacements = list.New()
+	bucket.members = make(map[util.ID]*list.Element)
+	bucket.addrs = make(map[netip.AddrPort]*list.Element)
+	bucket.candidates = make(map[util.ID]*list.Element)
+	bucket.lastTouched = time.Now()
 	return bucket
 }
 
+// LastTouched returns when the bucket last gained or refreshed a contact or
+// had a lookup run in its range
+func (bucket *Bucket) LastTouched() time.Time {
+	return bucket.lastTouched
+}
+
 // isFull returns true if the bucket is full
 func (bucket *Bucket) isFull() bool {
-	return bucket.list.Len() >= K
+	return bucket.list.Len() >= bucket.k
 }
 
 // GetLeastRecentlySeen returns the least recently seen Contact in the bucket
@@ -34,39 +61,113 @@ func (bucket *Bucket) GetLeastRecentlySeen() *Contact {
 	return &contact
 }
 
-// RemoveContact removes the Contact from the bucket
+// RemoveContact removes the Contact from the bucket and promotes the most
+// recently seen replacement into the freed slot
 func (bucket *Bucket) RemoveContact(contact Contact) {
-	var element *list.Element
-	for e := bucket.list.Front(); e != nil; e = e.Next() {
-		nodeID := e.Value.(Contact).ID
-		if (contact).ID.Equals(nodeID) {
-			element = e
-		}
+	bucket.dropReplacement(contact.ID)
+	e, ok := bucket.members[*contact.ID]
+	if !ok {
+		return
+	}
+	bucket.drop(e)
+	if front := bucket.replacements.Front(); front != nil {
+		promoted := front.Value.(Contact)
+		bucket.dropReplacement(promoted.ID)
+		bucket.push(promoted, false)
+	}
+}
+
+// AddReplacement remembers a contact that did not fit in the full bucket,
+// dropping the oldest candidate once it holds as many as the bucket
+func (bucket *Bucket) AddReplacement(contact Contact) {
+	contact.LastSeen = time.Now()
+	bucket.dropReplacement(contact.ID)
+	bucket.candidates[*contact.ID] = bucket.replacements.PushFront(contact)
+	if bucket.replacements.Len() > bucket.k {
+		bucket.dropReplacement(bucket.replacements.Back().Value.(Contact).ID)
+	}
+}
+
+// Replacements returns the replacement cache, most recently seen first
+func (bucket *Bucket) Replacements() []Contact {
+	var contacts []Contact
+	for e := bucket.replacements.Front(); e != nil; e = e.Next() {
+		contacts = append(contacts, e.Value.(Contact))
 	}
-	if element != nil {
-		bucket.list.Remove(element)
+	return contacts
+}
+
+// push inserts contact into the bucket's list and indexes, at the front
+// (most recently seen) or at the back
+func (bucket *Bucket) push(contact Contact, front bool) {
+	var e *list.Element
+	if front {
+		e = bucket.list.PushFront(contact)
+	} else {
+		e = bucket.list.PushBack(contact)
+	}
+	bucket.members[*contact.ID] = e
+	bucket.addrs[addrKey(&contact.Address)] = e
+}
+
+// drop removes the member held in e from the list and its indexes
+func (bucket *Bucket) drop(e *list.Element) {
+	c := e.Value.(Contact)
+	bucket.list.Remove(e)
+	delete(bucket.members, *c.ID)
+	// Another member may have taken over the address since
+	if key := addrKey(&c.Address); bucket.addrs[key] == e {
+		delete(bucket.addrs, key)
+	}
+}
+
+// dropReplacement removes the candidate with id from the replacement cache
+func (bucket *Bucket) dropReplacement(id *util.ID) {
+	if e, ok := bucket.candidates[*id]; ok {
+		bucket.replacements.Remove(e)
+		delete(bucket.candidates, *id)
 	}
 }
 
 // AddContact adds the Contact to the front of the bucket
-// or moves it to the front of the bucket if it already existed
+// or moves it to the front of the bucket if it already existed.
+// Either way the stored contact is stamped as seen now. Hearing from a
+// known contact clears its failures but keeps its last reply.
 func (bucket *Bucket) AddContact(contact Contact) {
-	var element *list.Element
-	for e := bucket.list.Front(); e != nil; e = e.Next() {
-		nodeID := e.Value.(Contact).ID
+	contact.LastSeen = time.Now()
+	bucket.lastTouched = contact.LastSeen
 
-		if (contact).ID.Equals(nodeID) {
-			element = e
+	element, ok := bucket.members[*contact.ID]
+	if !ok {
+		if bucket.list.Len() < bucket.k {
+			bucket.push(contact, true)
 		}
+		return
 	}
 
-	if element == nil {
-		if bucket.list.Len() < K {
-			bucket.list.PushFront(contact)
-		}
-	} else {
-		bucket.list.MoveToFront(element)
+	old := element.Value.(Contact)
+	if contact.LastReply.Before(old.LastReply) {
+		contact.LastReply = old.LastReply
+	}
+	contact.Failures = 0
+	element.Value = contact
+	bucket.list.MoveToFront(element)
+	if key := addrKey(&old.Address); key != addrKey(&contact.Address) && bucket.addrs[key] == element {
+		delete(bucket.addrs, key)
 	}
+	bucket.addrs[addrKey(&contact.Address)] = element
+}
+
+// find returns the element holding the contact at addr
+func (bucket *Bucket) find(addr *net.UDPAddr) *list.Element {
+	return bucket.addrs[addrKey(addr)]
+}
+
+// addrKey maps addr to a comparable key, treating an IPv4 address and its
+// IPv6-mapped form as one
+func addrKey(addr *net.UDPAddr) netip.AddrPort {
+	ap := addr.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
 }
 
 // GetContactAndCalcDistance returns an array of Contacts where
@@ -76,13 +177,51 @@ func (bucket *Bucket) GetContactAndCalcDistance(target *util.ID) []Contact {
 
 	for elt := bucket.list.Front(); elt != nil; elt = elt.Next() {
 		contact := elt.Value.(Contact)
+		contact.CalcDistance(target)
 		contacts = append(contacts, contact)
 	}
 
 	return contacts
 }
 
+// splitAt divides the bucket's contacts and replacements by the value of
+// their ID's bit, keeping their order
+func (bucket *Bucket) splitAt(bit int) (low, high *Bucket) {
+	low, high = newBucket(bucket.k), newBucket(bucket.k)
+	low.lastTouched, high.lastTouched = bucket.lastTouched, bucket.lastTouched
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		if c := e.Value.(Contact); c.ID.Bit(bit) {
+			high.push(c, false)
+		} else {
+			low.push(c, false)
+		}
+	}
+	for e := bucket.replacements.Front(); e != nil; e = e.Next() {
+		c := e.Value.(Contact)
+		half := low
+		if c.ID.Bit(bit) {
+			half = high
+		}
+		half.candidates[*c.ID] = half.replacements.PushBack(c)
+	}
+	return low, high
+}
+
 // Len return the size of the bucket
 func (bucket *Bucket) Len() int {
 	return bucket.list.Len()
 }
+
+// contains reports whether contact is a member of the bucket
+func (bucket *Bucket) contains(contact Contact) bool {
+	_, ok := bucket.members[*contact.ID]
+	return ok
+}
+
+// lookup returns the member with id
+func (bucket *Bucket) lookup(id *util.ID) (Contact, bool) {
+	if e, ok := bucket.members[*id]; ok {
+		return e.Value.(Contact), true
+	}
+	return Contact{}, false
+}
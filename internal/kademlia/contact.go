@@ -5,21 +5,43 @@ import (
 	"net"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/t0sic/D7024E-Kademlia/internal/util"
 )
 
+// MAX_FAILURES is how many RPCs in a row a contact may fail before it is
+// dropped from the routing table
+const MAX_FAILURES = 5
+
 // Contact definition
-// stores the util.ID, the ip address and the distance
+// stores the util.ID, the ip address, the distance, when it was last seen
+// and how it has answered our RPCs
 type Contact struct {
 	ID       *util.ID
 	Address  net.UDPAddr
 	Distance *util.ID
+	LastSeen time.Time
+	// LastReply is when it last answered one of our RPCs
+	LastReply time.Time
+	// Failures counts our RPCs it failed to answer since its last reply
+	Failures int
+}
+
+// recordReply notes in the liveness statistics that it answered at now
+func (contact *Contact) recordReply(now time.Time) {
+	contact.LastReply = now
+	contact.Failures = 0
+}
+
+// Fresh reports whether the contact answered its last RPC, or was never asked
+func (contact *Contact) Fresh() bool {
+	return contact.Failures == 0
 }
 
 // NewContact returns a new instance of a Contact
 func NewContact(id *util.ID, address *net.UDPAddr) Contact {
-	return Contact{id, *address, nil}
+	return Contact{ID: id, Address: *address}
 }
 
 func NewContactWithDistance(ref *util.ID, address *net.UDPAddr, from *util.ID) Contact {
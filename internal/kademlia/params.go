@@ -0,0 +1,59 @@
+package kademlia
+
+import (
+	"fmt"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// Params are the protocol parameters every node in a network must share
+type Params struct {
+	// K is the bucket size
+	K int
+	// Alpha is how many contacts a lookup queries at once
+	Alpha int
+	// Replication is how many nodes a value is stored on, and how many
+	// contacts lookups and NODES replies return
+	Replication int
+	// IDBytes is the width of node IDs and keys, util.IDBytes or util.WIDE_ID_BYTES
+	IDBytes int
+}
+
+// DefaultParams returns the classic Kademlia parameters: 160-bit IDs, K and ALPHA
+func DefaultParams() Params {
+	return Params{K: K, Alpha: ALPHA, Replication: K, IDBytes: util.IDBytes}
+}
+
+// Validate reports parameters no network can run with
+func (p Params) Validate() error {
+	switch {
+	case p.K < 1:
+		return fmt.Errorf("bucket size must be positive, got %d", p.K)
+	case p.Alpha < 1:
+		return fmt.Errorf("alpha must be positive, got %d", p.Alpha)
+	case p.Replication < 1:
+		return fmt.Errorf("replication factor must be positive, got %d", p.Replication)
+	case !util.ValidIDBytes(p.IDBytes):
+		return fmt.Errorf("ID width must be %d or %d bits, got %d", util.IDBytes*8, util.WIDE_ID_BYTES*8, p.IDBytes*8)
+	}
+	return nil
+}
+
+// String renders the parameters as k=20,alpha=3,r=20,bits=160
+func (p Params) String() string {
+	return fmt.Sprintf("k=%d,alpha=%d,r=%d,bits=%d", p.K, p.Alpha, p.Replication, p.IDBytes*8)
+}
+
+// ParseParams parses the output of Params.String
+func ParseParams(s string) (Params, error) {
+	var p Params
+	var bits int
+	if _, err := fmt.Sscanf(s, "k=%d,alpha=%d,r=%d,bits=%d", &p.K, &p.Alpha, &p.Replication, &bits); err != nil {
+		return Params{}, fmt.Errorf("bad parameters %q: %w", s, err)
+	}
+	p.IDBytes = bits / 8
+	if bits%8 != 0 {
+		p.IDBytes = 0
+	}
+	return p, p.Validate()
+}
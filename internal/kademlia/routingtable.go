@@ -1,47 +1,81 @@
 package kademlia
 
 import (
+	"net"
 	"sync"
+	"time"
 
 	"github.com/t0sic/D7024E-Kademlia/internal/util"
 )
 
+// Default bucket size and lookup concurrency, see Params
 const K = 20
 const ALPHA = 3
 
 // RoutingTable definition
-// keeps a refrence contact of me and an array of buckets
+// keeps a refrence contact of me, the network parameters and one bucket
+// per bit of the ID
 type RoutingTable struct {
 	mu      sync.RWMutex
 	me      Contact
-	buckets [util.IDBytes * 8]*Bucket
+	params  Params
+	buckets []*Bucket
 }
 
-// createRoutingTable returns a new instance of a RoutingTable
+// createRoutingTable returns a new instance of a RoutingTable with the
+// default parameters for the width of me's ID
 func CreateRoutingTable(me Contact) *RoutingTable {
-	routingTable := &RoutingTable{}
-	for i := 0; i < util.IDBytes*8; i++ {
-		routingTable.buckets[i] = newBucket()
+	params := DefaultParams()
+	params.IDBytes = me.ID.Len()
+	return CreateRoutingTableWithParams(me, params)
+}
+
+// CreateRoutingTableWithParams returns a RoutingTable whose buckets hold
+// params.K contacts. me's ID must be params.IDBytes wide.
+func CreateRoutingTableWithParams(me Contact, params Params) *RoutingTable {
+	routingTable := &RoutingTable{me: me, params: params}
+	routingTable.buckets = make([]*Bucket, params.IDBytes*8)
+	for i := range routingTable.buckets {
+		routingTable.buckets[i] = newBucket(params.K)
 	}
-	routingTable.me = me
 	return routingTable
 }
 
-// AddContact add a new contact to the correct Bucket
+// Params returns the parameters the table was created with
+func (routingTable *RoutingTable) Params() Params {
+	return routingTable.params
+}
+
+// AddContact add a new contact to the correct Bucket. If the bucket is full
+// and does not hold the contact yet, the contact goes to the bucket's
+// replacement cache and the least recently seen member is returned so the
+// caller can check whether it is still alive.
 func (routingTable *RoutingTable) AddContact(contact Contact) *Contact {
+	if contact.ID.Len() != routingTable.params.IDBytes {
+		return nil // from a network with other parameters
+	}
 	routingTable.mu.Lock()
 	defer routingTable.mu.Unlock()
 	bucketIndex := routingTable.getBucketIndex(contact.ID)
 	bucket := routingTable.buckets[bucketIndex]
 
-	if !bucket.isFull() {
+	if !bucket.isFull() || bucket.contains(contact) {
 		bucket.AddContact(contact)
 		return nil
 	}
+	bucket.AddReplacement(contact)
 	return bucket.GetLeastRecentlySeen()
 }
 
-// RemoveContact removes a contact from the correct Bucket
+// Replacements returns the replacement cache of the bucket covering id
+func (routingTable *RoutingTable) Replacements(id *util.ID) []Contact {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+	return routingTable.buckets[routingTable.getBucketIndex(id)].Replacements()
+}
+
+// RemoveContact removes a contact from the correct Bucket, promoting the
+// bucket's most recently seen replacement in its place
 func (routingTable *RoutingTable) RemoveContact(contact Contact) {
 	routingTable.mu.Lock()
 	defer routingTable.mu.Unlock()
@@ -51,46 +85,112 @@ func (routingTable *RoutingTable) RemoveContact(contact Contact) {
 	bucket.RemoveContact(contact)
 }
 
-// FindClosestContacts finds the count closest Contacts to the target in the RoutingTable
+// Lookup returns the table's entry for id, with its liveness statistics
+func (routingTable *RoutingTable) Lookup(id *util.ID) (Contact, bool) {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+	return lookupIn(routingTable.buckets[routingTable.getBucketIndex(id):][:1], id)
+}
+
+// LookupAddr returns the entry last seen at addr
+func (routingTable *RoutingTable) LookupAddr(addr *net.UDPAddr) (Contact, bool) {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+	return lookupAddrIn(routingTable.buckets, addr)
+}
+
+// RecordReply notes that the contact at addr answered an RPC
+func (routingTable *RoutingTable) RecordReply(addr *net.UDPAddr) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	recordReply(routingTable.buckets, addr)
+}
+
+// RecordFailure notes that the contact at addr failed to answer an RPC. After
+// MAX_FAILURES in a row it is removed, promoting a replacement, and true is
+// returned.
+func (routingTable *RoutingTable) RecordFailure(addr *net.UDPAddr) bool {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	return recordFailure(routingTable.buckets, addr)
+}
+
+// FindClosestContacts finds the count closest Contacts to the target in the
+// RoutingTable. Contacts that failed their last RPC are only returned when
+// there are not enough fresh ones.
+//
+// Buckets are visited in order of distance to the target. Contacts in the
+// target's own bucket share more leading bits with it than any others, those
+// in all deeper buckets share exactly as many as the target shares with us,
+// and each shallower bucket is further than the one below it. Whole groups
+// are taken until there are enough, so the result is exact.
 func (routingTable *RoutingTable) FindClosestContacts(target *util.ID, count int) []Contact {
-	var candidates ContactCandidates
+	selection := newSelection(target, count)
 	bucketIndex := routingTable.getBucketIndex(target)
-	bucket := routingTable.buckets[bucketIndex]
 
 	routingTable.mu.RLock()
-	candidates.Append(bucket.GetContactAndCalcDistance(target))
-
-	for i := 1; (bucketIndex-i >= 0 || bucketIndex+i < util.IDBytes*8) && candidates.Len() < count; i++ {
-		if bucketIndex-i >= 0 {
-			bucket = routingTable.buckets[bucketIndex-i]
-			candidates.Append(bucket.GetContactAndCalcDistance(target))
-		}
-		if bucketIndex+i < util.IDBytes*8 {
-			bucket = routingTable.buckets[bucketIndex+i]
-			candidates.Append(bucket.GetContactAndCalcDistance(target))
+	selection.add(routingTable.buckets[bucketIndex])
+	if !selection.full() {
+		for _, bucket := range routingTable.buckets[bucketIndex+1:] {
+			selection.add(bucket)
 		}
 	}
+	for i := bucketIndex - 1; i >= 0 && !selection.full(); i-- {
+		selection.add(routingTable.buckets[i])
+	}
 	routingTable.mu.RUnlock()
 
-	candidates.Sort()
+	return selection.closest()
+}
 
-	if count > candidates.Len() {
-		count = candidates.Len()
+// Contacts returns every contact in the RoutingTable, closest buckets last
+func (routingTable *RoutingTable) Contacts() []Contact {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+	return contactsIn(routingTable.buckets)
+}
+
+// Touch marks the bucket covering target as used, as a lookup for target does
+func (routingTable *RoutingTable) Touch(target *util.ID) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	routingTable.buckets[routingTable.getBucketIndex(target)].lastTouched = time.Now()
+}
+
+// StaleBuckets returns the indexes of buckets untouched since before. Buckets
+// deeper than the deepest non-empty one are skipped: they cover ranges so
+// close to us that no other node is expected in them.
+func (routingTable *RoutingTable) StaleBuckets(before time.Time) []int {
+	routingTable.mu.RLock()
+	defer routingTable.mu.RUnlock()
+
+	deepest := -1
+	for i, bucket := range routingTable.buckets {
+		if bucket.Len() > 0 {
+			deepest = i
+		}
+	}
+
+	var stale []int
+	for i := 0; i <= deepest; i++ {
+		if routingTable.buckets[i].lastTouched.Before(before) {
+			stale = append(stale, i)
+		}
 	}
+	return stale
+}
 
-	return candidates.GetContacts(count)
+// RandomIDInBucket returns a random ID that falls in bucket index: it shares
+// exactly index leading bits with our own ID.
+func (routingTable *RoutingTable) RandomIDInBucket(index int) util.ID {
+	prefix := routingTable.me.ID.FlipBit(index)
+	return util.RandomIDWithPrefix(prefix, index+1)
 }
 
 // getBucketIndex get the correct Bucket index for the KademliaID
 func (routingTable *RoutingTable) getBucketIndex(id *util.ID) int {
-	distance := id.CalcDistance(routingTable.me.ID)
-	for i := 0; i < util.IDBytes; i++ {
-		for j := 0; j < 8; j++ {
-			if (distance[i]>>uint8(7-j))&0x1 != 0 {
-				return i*8 + j
-			}
-		}
+	if cpl := id.CommonPrefixLen(routingTable.me.ID); cpl < len(routingTable.buckets) {
+		return cpl
 	}
-
-	return util.IDBytes*8 - 1
+	return len(routingTable.buckets) - 1
 }
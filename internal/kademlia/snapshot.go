@@ -0,0 +1,95 @@
+package kademlia
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// snapshotContact is the on-disk form of a Contact
+type snapshotContact struct {
+	ID       string    `json:"id"`
+	Addr     string    `json:"addr"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// snapshot is the on-disk form of a RoutingTable
+type snapshot struct {
+	SavedAt  time.Time         `json:"saved_at"`
+	Contacts []snapshotContact `json:"contacts"`
+}
+
+// SaveSnapshot writes every contact in the RoutingTable to path.
+// The file is replaced atomically so a crash never leaves a partial snapshot.
+func (routingTable *RoutingTable) SaveSnapshot(path string) error {
+	return saveSnapshot(routingTable.Contacts(), path)
+}
+
+// SaveSnapshot writes every contact in the TreeTable to path, like
+// RoutingTable.SaveSnapshot.
+func (tree *TreeTable) SaveSnapshot(path string) error {
+	return saveSnapshot(tree.Contacts(), path)
+}
+
+func saveSnapshot(contacts []Contact, path string) error {
+	snap := snapshot{SavedAt: time.Now()}
+	for _, c := range contacts {
+		snap.Contacts = append(snap.Contacts, snapshotContact{
+			ID:       c.ID.String(),
+			Addr:     c.Address.String(),
+			LastSeen: c.LastSeen,
+		})
+	}
+
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create snapshot dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadSnapshot reads the contacts saved by SaveSnapshot, most recently seen first.
+// Entries that no longer parse are skipped.
+func LoadSnapshot(path string) ([]Contact, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	contacts := make([]Contact, 0, len(snap.Contacts))
+	for _, sc := range snap.Contacts {
+		id, err := util.ParseHexID(sc.ID)
+		if err != nil {
+			continue
+		}
+		addr, err := net.ResolveUDPAddr("udp", sc.Addr)
+		if err != nil {
+			continue
+		}
+		c := NewContact(&id, addr)
+		c.LastSeen = sc.LastSeen
+		contacts = append(contacts, c)
+	}
+
+	sort.SliceStable(contacts, func(i, j int) bool {
+		return contacts[i].LastSeen.After(contacts[j].LastSeen)
+	})
+	return contacts, nil
+}
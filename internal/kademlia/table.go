@@ -0,0 +1,188 @@
+package kademlia
+
+import (
+	"container/heap"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// Routing table layouts, see NewTable
+const (
+	// TABLE_FLAT is RoutingTable: one bucket per shared prefix length
+	TABLE_FLAT = "flat"
+	// TABLE_TREE is TreeTable: buckets split on demand, as in the paper
+	TABLE_TREE = "tree"
+)
+
+// Table is a routing table. Bucket indexes, as returned by StaleBuckets and
+// taken by RandomIDInBucket, are only meaningful to the table that issued them.
+type Table interface {
+	// AddContact adds or refreshes contact. If its bucket is full the
+	// contact is cached as a replacement and the least recently seen member
+	// is returned so the caller can check whether it is still alive.
+	AddContact(contact Contact) *Contact
+	// RemoveContact removes contact, promoting a cached replacement
+	RemoveContact(contact Contact)
+	// Replacements returns the replacement cache of the bucket covering id
+	Replacements(id *util.ID) []Contact
+	// Lookup returns the entry for id, with its liveness statistics
+	Lookup(id *util.ID) (Contact, bool)
+	// LookupAddr returns the entry last seen at addr
+	LookupAddr(addr *net.UDPAddr) (Contact, bool)
+	RecordReply(addr *net.UDPAddr)
+	RecordFailure(addr *net.UDPAddr) bool
+	// FindClosestContacts returns up to count contacts closest to target,
+	// preferring contacts that answered their last RPC
+	FindClosestContacts(target *util.ID, count int) []Contact
+	Contacts() []Contact
+	Touch(target *util.ID)
+	StaleBuckets(before time.Time) []int
+	RandomIDInBucket(index int) util.ID
+	Params() Params
+	SaveSnapshot(path string) error
+}
+
+// NewTable returns an empty table of the given layout. relaxed only applies
+// to TABLE_TREE, see TreeTable.
+func NewTable(layout string, me Contact, params Params, relaxed bool) (Table, error) {
+	switch layout {
+	case TABLE_FLAT:
+		return CreateRoutingTableWithParams(me, params), nil
+	case TABLE_TREE:
+		return CreateTreeTable(me, params, relaxed), nil
+	default:
+		return nil, fmt.Errorf("unknown routing table layout %q", layout)
+	}
+}
+
+// lookupIn returns the entry for id in buckets
+func lookupIn(buckets []*Bucket, id *util.ID) (Contact, bool) {
+	for _, bucket := range buckets {
+		if c, ok := bucket.lookup(id); ok {
+			return c, true
+		}
+	}
+	return Contact{}, false
+}
+
+// lookupAddrIn returns the entry at addr in buckets
+func lookupAddrIn(buckets []*Bucket, addr *net.UDPAddr) (Contact, bool) {
+	for _, bucket := range buckets {
+		if e := bucket.find(addr); e != nil {
+			return e.Value.(Contact), true
+		}
+	}
+	return Contact{}, false
+}
+
+// recordReply updates the contact at addr in buckets after it answered
+func recordReply(buckets []*Bucket, addr *net.UDPAddr) {
+	for _, bucket := range buckets {
+		if e := bucket.find(addr); e != nil {
+			c := e.Value.(Contact)
+			c.recordReply(time.Now())
+			e.Value = c
+			return
+		}
+	}
+}
+
+// recordFailure counts a failed RPC against the contact at addr in buckets
+// and removes it after MAX_FAILURES in a row, reporting whether it did
+func recordFailure(buckets []*Bucket, addr *net.UDPAddr) bool {
+	for _, bucket := range buckets {
+		if e := bucket.find(addr); e != nil {
+			c := e.Value.(Contact)
+			c.Failures++
+			e.Value = c
+			if c.Failures >= MAX_FAILURES {
+				bucket.RemoveContact(c)
+				return true
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// contactsIn returns every contact in buckets, in bucket order
+func contactsIn(buckets []*Bucket) []Contact {
+	var contacts []Contact
+	for _, bucket := range buckets {
+		for elt := bucket.list.Front(); elt != nil; elt = elt.Next() {
+			contacts = append(contacts, elt.Value.(Contact))
+		}
+	}
+	return contacts
+}
+
+// selection keeps the count contacts closest to a target seen so far,
+// fresh and stale apart, so a table can stream its buckets through it
+// instead of copying and sorting them all
+type selection struct {
+	target       *util.ID
+	count        int
+	fresh, stale farthestFirst
+}
+
+func newSelection(target *util.ID, count int) *selection {
+	return &selection{target: target, count: count}
+}
+
+// add offers every contact in bucket
+func (s *selection) add(bucket *Bucket) {
+	if s.count <= 0 {
+		return
+	}
+	for e := bucket.list.Front(); e != nil; e = e.Next() {
+		c := e.Value.(Contact)
+		h := &s.fresh
+		if !c.Fresh() {
+			h = &s.stale
+		}
+		if len(*h) < s.count {
+			c.CalcDistance(s.target)
+			heap.Push(h, c)
+		} else if c.ID.CloserTo(s.target, (*h)[0].ID) {
+			c.CalcDistance(s.target)
+			(*h)[0] = c
+			heap.Fix(h, 0)
+		}
+	}
+}
+
+// full reports whether count fresh contacts have been seen
+func (s *selection) full() bool {
+	return len(s.fresh) >= s.count
+}
+
+// closest returns up to count contacts sorted by distance. Contacts that
+// failed their last RPC are only picked when there are not enough fresh ones.
+func (s *selection) closest() []Contact {
+	closest := append([]Contact(nil), s.fresh...)
+	if missing := s.count - len(closest); missing > 0 {
+		stale := ContactCandidates{contacts: s.stale}
+		stale.Sort()
+		closest = append(closest, stale.GetContacts(missing)...)
+	}
+	sort.Sort(&ContactCandidates{contacts: closest})
+	return closest
+}
+
+// farthestFirst is a heap of contacts with the furthest at the top
+type farthestFirst []Contact
+
+func (h farthestFirst) Len() int           { return len(h) }
+func (h farthestFirst) Less(i, j int) bool { return h[j].Less(&h[i]) }
+func (h farthestFirst) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *farthestFirst) Push(x any)        { *h = append(*h, x.(Contact)) }
+func (h *farthestFirst) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
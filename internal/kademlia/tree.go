@@ -0,0 +1,250 @@
+package kademlia
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TreeTable is a routing table laid out as in the Kademlia paper. It starts
+// with a single bucket covering the whole ID space and splits a full bucket
+// in two when the bucket covers our own ID. With relaxed splitting, a full
+// bucket elsewhere is split as well when the new contact would be among the
+// K contacts closest to us, so that a sparse or unbalanced region near our
+// ID keeps every node it has instead of caching them as replacements.
+type TreeTable struct {
+	mu      sync.RWMutex
+	me      Contact
+	params  Params
+	relaxed bool
+	leaves  []*leaf // ordered by prefix
+}
+
+// leaf is a bucket covering the IDs whose first depth bits are those of prefix
+type leaf struct {
+	prefix util.ID
+	depth  int
+	bucket *Bucket
+}
+
+func (l *leaf) covers(id *util.ID) bool {
+	return id.CommonPrefixLen(&l.prefix) >= l.depth
+}
+
+// CreateTreeTable returns a TreeTable with one empty bucket
+func CreateTreeTable(me Contact, params Params, relaxed bool) *TreeTable {
+	prefix, _ := util.IDFromBytes(make([]byte, params.IDBytes))
+	root := &leaf{prefix: prefix, bucket: newBucket(params.K)}
+	return &TreeTable{me: me, params: params, relaxed: relaxed, leaves: []*leaf{root}}
+}
+
+// Params returns the parameters the table was created with
+func (tree *TreeTable) Params() Params {
+	return tree.params
+}
+
+// leafFor returns the index of the leaf covering id; callers hold tree.mu
+func (tree *TreeTable) leafFor(id *util.ID) int {
+	for i, l := range tree.leaves {
+		if l.covers(id) {
+			return i
+		}
+	}
+	return len(tree.leaves) - 1 // unreachable: the leaves cover the whole space
+}
+
+// buckets returns the leaves' buckets in prefix order; callers hold tree.mu
+func (tree *TreeTable) buckets() []*Bucket {
+	buckets := make([]*Bucket, len(tree.leaves))
+	for i, l := range tree.leaves {
+		buckets[i] = l.bucket
+	}
+	return buckets
+}
+
+// AddContact adds contact, splitting its bucket while it is full and may be
+// split. Otherwise it behaves like RoutingTable.AddContact.
+func (tree *TreeTable) AddContact(contact Contact) *Contact {
+	if contact.ID.Len() != tree.params.IDBytes {
+		return nil // from a network with other parameters
+	}
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+
+	for {
+		i := tree.leafFor(contact.ID)
+		bucket := tree.leaves[i].bucket
+		if !bucket.isFull() || bucket.contains(contact) {
+			bucket.AddContact(contact)
+			return nil
+		}
+		if !tree.splittable(tree.leaves[i], contact) {
+			bucket.AddReplacement(contact)
+			return bucket.GetLeastRecentlySeen()
+		}
+		tree.split(i)
+	}
+}
+
+// splittable reports whether a full leaf may split to make room for
+// contact; callers hold tree.mu
+func (tree *TreeTable) splittable(l *leaf, contact Contact) bool {
+	if l.depth >= tree.params.IDBytes*8 {
+		return false
+	}
+	if l.covers(tree.me.ID) {
+		return true
+	}
+	if !tree.relaxed {
+		return false
+	}
+	// Is contact among the K closest to us that we know of?
+	distance := contact.ID.CalcDistance(tree.me.ID)
+	closer := 0
+	for _, c := range contactsIn(tree.buckets()) {
+		if c.ID.CalcDistance(tree.me.ID).Less(distance) {
+			if closer++; closer >= tree.params.K {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// split replaces leaf i by two leaves one bit deeper; callers hold tree.mu
+func (tree *TreeTable) split(i int) {
+	l := tree.leaves[i]
+	low, high := l.bucket.splitAt(l.depth)
+	lowLeaf := &leaf{prefix: l.prefix, depth: l.depth + 1, bucket: low}
+	highLeaf := &leaf{prefix: l.prefix.FlipBit(l.depth), depth: l.depth + 1, bucket: high}
+	if l.prefix.Bit(l.depth) {
+		lowLeaf.prefix, highLeaf.prefix = highLeaf.prefix, lowLeaf.prefix
+	}
+
+	leaves := append([]*leaf{}, tree.leaves[:i]...)
+	leaves = append(leaves, lowLeaf, highLeaf)
+	tree.leaves = append(leaves, tree.leaves[i+1:]...)
+}
+
+// Replacements returns the replacement cache of the bucket covering id
+func (tree *TreeTable) Replacements(id *util.ID) []Contact {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	return tree.leaves[tree.leafFor(id)].bucket.Replacements()
+}
+
+// RemoveContact removes a contact from its bucket, promoting the bucket's
+// most recently seen replacement in its place. Buckets are never merged.
+func (tree *TreeTable) RemoveContact(contact Contact) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	tree.leaves[tree.leafFor(contact.ID)].bucket.RemoveContact(contact)
+}
+
+// Lookup returns the table's entry for id, with its liveness statistics
+func (tree *TreeTable) Lookup(id *util.ID) (Contact, bool) {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	return lookupIn([]*Bucket{tree.leaves[tree.leafFor(id)].bucket}, id)
+}
+
+// LookupAddr is RoutingTable.LookupAddr for the tree layout
+func (tree *TreeTable) LookupAddr(addr *net.UDPAddr) (Contact, bool) {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	return lookupAddrIn(tree.buckets(), addr)
+}
+
+// RecordReply is RoutingTable.RecordReply for the tree layout
+func (tree *TreeTable) RecordReply(addr *net.UDPAddr) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	recordReply(tree.buckets(), addr)
+}
+
+// RecordFailure is RoutingTable.RecordFailure for the tree layout
+func (tree *TreeTable) RecordFailure(addr *net.UDPAddr) bool {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	return recordFailure(tree.buckets(), addr)
+}
+
+// FindClosestContacts finds the count closest Contacts to the target,
+// preferring contacts that answered their last RPC. Leaves cover disjoint
+// ranges, so every ID in one is closer to the target than every ID in
+// another; they are visited nearest first until there are enough contacts.
+func (tree *TreeTable) FindClosestContacts(target *util.ID, count int) []Contact {
+	tree.mu.RLock()
+	leaves := append([]*leaf(nil), tree.leaves...)
+	sort.Slice(leaves, func(i, j int) bool {
+		// The first bit the prefixes differ in decides which is closer
+		bit := leaves[i].prefix.CommonPrefixLen(&leaves[j].prefix)
+		return leaves[i].prefix.Bit(bit) == target.Bit(bit)
+	})
+
+	selection := newSelection(target, count)
+	for _, l := range leaves {
+		if selection.full() {
+			break
+		}
+		selection.add(l.bucket)
+	}
+	tree.mu.RUnlock()
+	return selection.closest()
+}
+
+// Contacts returns every contact in the table, in prefix order
+func (tree *TreeTable) Contacts() []Contact {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	return contactsIn(tree.buckets())
+}
+
+// Touch marks the bucket covering target as used, as a lookup for target does
+func (tree *TreeTable) Touch(target *util.ID) {
+	tree.mu.Lock()
+	defer tree.mu.Unlock()
+	tree.leaves[tree.leafFor(target)].bucket.lastTouched = time.Now()
+}
+
+// StaleBuckets returns the indexes of buckets untouched since before
+func (tree *TreeTable) StaleBuckets(before time.Time) []int {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+
+	var stale []int
+	for i, l := range tree.leaves {
+		if l.bucket.lastTouched.Before(before) {
+			stale = append(stale, i)
+		}
+	}
+	return stale
+}
+
+// RandomIDInBucket returns a random ID covered by bucket index. An index
+// made stale by a split since StaleBuckets gives a random ID anywhere.
+func (tree *TreeTable) RandomIDInBucket(index int) util.ID {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	if index < 0 || index >= len(tree.leaves) {
+		return util.RandomIDWithPrefix(*tree.me.ID, 0)
+	}
+	l := tree.leaves[index]
+	return util.RandomIDWithPrefix(l.prefix, l.depth)
+}
+
+// Depth returns how many bits the deepest bucket's prefix has
+func (tree *TreeTable) Depth() int {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	depth := 0
+	for _, l := range tree.leaves {
+		if l.depth > depth {
+			depth = l.depth
+		}
+	}
+	return depth
+}
@@ -1,8 +1,8 @@
 package net
 
 import (
+	"context"
 	"net"
-	"time"
 )
 
 // Network interface abstracts the network layer for Kademlia nodes
@@ -12,5 +12,7 @@ type Network interface {
 	Close() error
 	Addr() *net.UDPAddr
 
-	SendAndWait(to *net.UDPAddr, msg Message, timeout time.Duration) (Message, error)
+	// SendAndWait sends msg and waits for its reply until ctx is done;
+	// callers bound the wait with a deadline on ctx
+	SendAndWait(ctx context.Context, to *net.UDPAddr, msg Message) (Message, error)
 }
@@ -0,0 +1,270 @@
+package net
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// WIRE FORMATS
+const FORMAT_TEXT = "text"
+const FORMAT_BINARY = "binary"
+
+// BINARY_MAGIC starts every binary packet. Text packets always start with
+// an ASCII message type, so the first byte tells the two formats apart.
+const BINARY_MAGIC = 0xCA
+
+// BINARY_VERSION is the version of the binary layout written by this node
+const BINARY_VERSION = 1
+
+// BINARY_ADVERT prefixes the RPC ID of text packets sent by nodes that can
+// also speak binary. Text-only nodes echo the RPC ID untouched, so the
+// prefix is invisible to them, while binary-capable peers switch formats.
+const BINARY_ADVERT = "b1."
+
+// Binary layout (version 1):
+//
+//	magic(1) version(1) type(1) [typeName] rpcID argc(uvarint) arg...
+//
+// A type byte of 0 is followed by the type name as a string field, so new
+// message types work before they are given a code. The RPC ID and every
+// argument are encoded as a tag byte followed by the field:
+//
+//	argString  uvarint length + bytes
+//	argHex     uvarint length + raw bytes of a lowercase hex string
+//	argContact uvarint id length + raw id + ip length(1) + ip + port(2)
+const (
+	argString  byte = 0
+	argHex     byte = 1
+	argContact byte = 2
+)
+
+// typeCodes are the message types known when BINARY_VERSION 1 was fixed.
+// A v1 peer rejects any other code, so types added since are sent by name;
+// giving them codes needs a new BINARY_VERSION.
+var typeCodes = map[string]byte{
+	MSG_PING:      1,
+	MSG_PONG:      2,
+	MSG_FIND_NODE: 3,
+	MSG_NODES:     4,
+	MSG_STORE:     5,
+	MSG_STORED:    6,
+	MSG_GET:       7,
+	MSG_VALUE:     8,
+	MSG_NOT_FOUND: 9,
+}
+
+var typeNames = func() map[byte]string {
+	names := make(map[byte]string, len(typeCodes))
+	for name, code := range typeCodes {
+		names[code] = name
+	}
+	return names
+}()
+
+// IsBinary reports whether a packet uses the binary format
+func IsBinary(packet []byte) bool {
+	return len(packet) > 0 && packet[0] == BINARY_MAGIC
+}
+
+// DecodePacket parses a packet in either format and reports which one it was.
+func DecodePacket(packet []byte) (Message, string, error) {
+	if IsBinary(packet) {
+		msg, err := DecodeBinary(packet)
+		return msg, FORMAT_BINARY, err
+	}
+	msg, err := ParseMessage(string(packet))
+	return msg, FORMAT_TEXT, err
+}
+
+// EncodeBinary renders a message in the binary format. Arguments that are
+// hex strings or contact tokens are sent as raw bytes, everything else
+// verbatim, so DecodeBinary always returns the original message.
+func EncodeBinary(m Message) []byte {
+	buf := []byte{BINARY_MAGIC, BINARY_VERSION}
+
+	if code, ok := typeCodes[m.Type]; ok {
+		buf = append(buf, code)
+	} else {
+		buf = append(buf, 0)
+		buf = appendBytes(buf, []byte(m.Type))
+	}
+
+	buf = appendArg(buf, m.RPCID)
+	buf = binary.AppendUvarint(buf, uint64(len(m.Args)))
+	for _, a := range m.Args {
+		buf = appendArg(buf, a)
+	}
+	return buf
+}
+
+// DecodeBinary parses a packet produced by EncodeBinary
+func DecodeBinary(packet []byte) (Message, error) {
+	if len(packet) < 3 || packet[0] != BINARY_MAGIC {
+		return Message{}, fmt.Errorf("not a binary message")
+	}
+	if packet[1] != BINARY_VERSION {
+		return Message{}, fmt.Errorf("unsupported binary version %d", packet[1])
+	}
+	r := &reader{buf: packet[3:]}
+
+	var msg Message
+	if code := packet[2]; code == 0 {
+		msg.Type = string(r.bytes())
+	} else if name, ok := typeNames[code]; ok {
+		msg.Type = name
+	} else {
+		return Message{}, fmt.Errorf("unknown message type code %d", code)
+	}
+
+	msg.RPCID = r.arg()
+	argc := r.uvarint()
+	if r.err == nil && argc > uint64(len(r.buf)) {
+		r.err = fmt.Errorf("argument count %d exceeds packet", argc)
+	}
+	for i := uint64(0); i < argc && r.err == nil; i++ {
+		msg.Args = append(msg.Args, r.arg())
+	}
+	if r.err != nil {
+		return Message{}, r.err
+	}
+	if len(r.buf) != 0 {
+		return Message{}, fmt.Errorf("%d trailing bytes", len(r.buf))
+	}
+	return msg, nil
+}
+
+// appendArg writes a with the most compact tag that round-trips exactly
+func appendArg(buf []byte, a string) []byte {
+	if raw, ok := exactHex(a); ok {
+		return appendBytes(append(buf, argHex), raw)
+	}
+	if id, ip, port, ok := exactContact(a); ok {
+		buf = appendBytes(append(buf, argContact), id)
+		buf = append(buf, byte(len(ip)))
+		buf = append(buf, ip...)
+		return binary.BigEndian.AppendUint16(buf, port)
+	}
+	return appendBytes(append(buf, argString), []byte(a))
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// exactHex returns the bytes of a non-empty lowercase hex string
+func exactHex(s string) ([]byte, bool) {
+	if s == "" || len(s)%2 != 0 {
+		return nil, false
+	}
+	raw, err := hex.DecodeString(s)
+	if err != nil || hex.EncodeToString(raw) != s {
+		return nil, false
+	}
+	return raw, true
+}
+
+// exactContact splits an <hexID>@<ip:port> token that formatContact reproduces
+func exactContact(s string) ([]byte, net.IP, uint16, bool) {
+	idHex, addr, ok := strings.Cut(s, "@")
+	if !ok {
+		return nil, nil, 0, false
+	}
+	id, ok := exactHex(idHex)
+	if !ok {
+		return nil, nil, 0, false
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, nil, 0, false
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if ip == nil || err != nil {
+		return nil, nil, 0, false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if formatContact(id, ip, uint16(port)) != s {
+		return nil, nil, 0, false
+	}
+	return id, ip, uint16(port), true
+}
+
+func formatContact(id []byte, ip net.IP, port uint16) string {
+	addr := net.UDPAddr{IP: ip, Port: int(port)}
+	return hex.EncodeToString(id) + "@" + addr.String()
+}
+
+// reader consumes binary fields, remembering the first error
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = fmt.Errorf("bad length prefix")
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+func (r *reader) take(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)) {
+		r.err = fmt.Errorf("field of %d bytes exceeds packet", n)
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) bytes() []byte {
+	return r.take(r.uvarint())
+}
+
+func (r *reader) arg() string {
+	tag := r.take(1)
+	if r.err != nil {
+		return ""
+	}
+	switch tag[0] {
+	case argString:
+		return string(r.bytes())
+	case argHex:
+		return hex.EncodeToString(r.bytes())
+	case argContact:
+		id := r.bytes()
+		ipLen := r.take(1)
+		if r.err != nil {
+			return ""
+		}
+		ip := r.take(uint64(ipLen[0]))
+		port := r.take(2)
+		if r.err != nil {
+			return ""
+		}
+		if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+			r.err = fmt.Errorf("bad contact ip length %d", len(ip))
+			return ""
+		}
+		return formatContact(id, net.IP(ip), binary.BigEndian.Uint16(port))
+	default:
+		r.err = fmt.Errorf("unknown argument tag %d", tag[0])
+		return ""
+	}
+}
@@ -1,10 +1,12 @@
 package net
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/t0sic/D7024E-Kademlia/internal/util"
@@ -16,7 +18,12 @@ var (
 )
 
 type MockUDP struct {
-	addr     *net.UDPAddr
+	addr    *net.UDPAddr
+	latency atomic.Int64 // round trip added to requests sent here, in ns
+
+	// The peer is reachable as soon as it is registered, so requests can
+	// arrive while its owner is still adding handlers
+	mu       sync.RWMutex
 	handlers map[string]Handler
 }
 
@@ -43,12 +50,21 @@ func (m *MockUDP) Close() error {
 	return nil
 }
 
+// SetLatency makes every request to this peer take d before its reply
+// arrives, or fail once the sender's context is done if that comes first
+func (m *MockUDP) SetLatency(d time.Duration) { m.latency.Store(int64(d)) }
+
 func (m *MockUDP) On(typ string, h Handler) {
+	m.mu.Lock()
 	m.handlers[strings.ToUpper(strings.TrimSpace(typ))] = h
+	m.mu.Unlock()
 }
 
-func (m *MockUDP) SendAndWait(to *net.UDPAddr, msg Message, timeout time.Duration) (Message, error) {
+func (m *MockUDP) SendAndWait(ctx context.Context, to *net.UDPAddr, msg Message) (Message, error) {
 	// mimic UDP SendAndWait: set an RPCID, call handler, return reply with same RPCID
+	if err := ctx.Err(); err != nil {
+		return Message{}, err
+	}
 	if msg.RPCID == "" {
 		msg.RPCID = util.NewRandomID().Hex()
 	}
@@ -57,10 +73,24 @@ func (m *MockUDP) SendAndWait(to *net.UDPAddr, msg Message, timeout time.Duratio
 	dst := mockReg[to.String()]
 	mockRegMu.RUnlock()
 	if dst == nil {
-		return Message{}, fmt.Errorf("no mock peer at %s", to)
+		// Like a datagram to a host that is gone, it fails once ctx is done
+		<-ctx.Done()
+		return Message{}, fmt.Errorf("no mock peer at %s: %w", to, ctx.Err())
+	}
+
+	if d := time.Duration(dst.latency.Load()); d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return Message{}, fmt.Errorf("waiting for rpcID=%s: %w", msg.RPCID, ctx.Err())
+		}
 	}
 
+	dst.mu.RLock()
 	h := dst.handlers[msg.Type]
+	dst.mu.RUnlock()
 	if h == nil {
 		return Message{}, fmt.Errorf("no handler for %s at %s", msg.Type, to)
 	}
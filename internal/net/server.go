@@ -1,6 +1,7 @@
 package net
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -14,19 +15,29 @@ const BUFFER_SIZE = 4096
 const READ_TIMEOUT = 5 * time.Second
 const WRITE_TIMEOUT = 5 * time.Second
 
+// MAX_PEER_FORMATS bounds how many peers' wire formats are remembered
+const MAX_PEER_FORMATS = 4096
+
 // MESSAGE TYPES
 const MSG_PING = "PING"
 const MSG_PONG = "PONG"
 const MSG_NODES = "NODES"
 const MSG_FIND_NODE = "FIND_NODE"
+const MSG_FIND_VALUE = "FIND_VALUE"
 
 const MSG_STORE = "STORE"
 const MSG_STORED = "STORED"
+const MSG_STORE_REFUSED = "STORE_REFUSED"
 
 const MSG_GET = "GET"
 const MSG_VALUE = "VALUE"
 const MSG_NOT_FOUND = "NOT_FOUND"
 
+// Signed mutable records, stored and fetched under the hash of the public key
+const MSG_PUT_MUTABLE = "PUT_MUTABLE"
+const MSG_GET_MUTABLE = "GET_MUTABLE"
+const MSG_MUTABLE_VALUE = "MUTABLE_VALUE"
+
 // WAITER PROTOCOL
 type waiter struct {
 	ch chan Message
@@ -76,6 +87,7 @@ func (m Message) String() string {
 type Handler func(from *net.UDPAddr, msg Message) (*Message, error)
 
 type UDPServer struct {
+	// addr and conn are set by Start and guarded by mu
 	addr *net.UDPAddr
 	conn *net.UDPConn
 
@@ -86,10 +98,27 @@ type UDPServer struct {
 
 	waiters map[string]*waiter
 	wmu     sync.Mutex
+
+	// format is the preferred wire format; peerFormats remembers what each
+	// peer is known to speak so binary is only sent to nodes that accept it
+	format      string
+	peerFormats map[string]string
+	pmu         sync.Mutex
 }
 
 // expose bound address (handy for :0)
-func (s *UDPServer) Addr() *net.UDPAddr { return s.addr }
+func (s *UDPServer) Addr() *net.UDPAddr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.addr
+}
+
+// connection returns the socket opened by Start, or nil before it
+func (s *UDPServer) connection() *net.UDPConn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.conn
+}
 
 // Close shuts down the UDP server connection.
 func (s *UDPServer) Close() error {
@@ -100,8 +129,8 @@ func (s *UDPServer) Close() error {
 	// Unblock any goroutines waiting on RPC responses
 	s.CancelAllWaiters()
 
-	if s.conn != nil {
-		_ = s.conn.Close() // triggers read deadline + loop exit
+	if conn := s.connection(); conn != nil {
+		_ = conn.Close() // triggers read deadline + loop exit
 	}
 
 	// Wait until Start() returns and wg.Done() executes
@@ -122,10 +151,87 @@ func CreateUDPServer(addr string) *UDPServer {
 		panic(err)
 	}
 	return &UDPServer{
-		addr:     udpAddr,
-		handlers: make(map[string]Handler),
-		waiters:  make(map[string]*waiter),
+		addr:        udpAddr,
+		handlers:    make(map[string]Handler),
+		waiters:     make(map[string]*waiter),
+		format:      FORMAT_BINARY,
+		peerFormats: make(map[string]string),
+	}
+}
+
+// SetWireFormat selects the preferred wire format. With FORMAT_BINARY the
+// server advertises binary support over text and switches per peer once
+// the peer is known to speak it; FORMAT_TEXT never sends binary.
+func (s *UDPServer) SetWireFormat(format string) error {
+	if format != FORMAT_TEXT && format != FORMAT_BINARY {
+		return fmt.Errorf("unknown wire format %q (want %s or %s)", format, FORMAT_TEXT, FORMAT_BINARY)
+	}
+	s.pmu.Lock()
+	s.format = format
+	s.pmu.Unlock()
+	return nil
+}
+
+// sendFormat returns the format to use for a new request to peer and
+// whether a text request should advertise binary support
+func (s *UDPServer) sendFormat(peer *net.UDPAddr) (string, bool) {
+	s.pmu.Lock()
+	defer s.pmu.Unlock()
+	if s.format == FORMAT_TEXT {
+		return FORMAT_TEXT, false
+	}
+	if s.peerFormats[peer.String()] == FORMAT_BINARY {
+		return FORMAT_BINARY, false
+	}
+	return FORMAT_TEXT, true
+}
+
+// learnFormat records what a peer speaks from a packet it sent us and
+// returns the format a reply to that packet should use. advertised is set
+// for text requests carrying BINARY_ADVERT; replies merely echo our own
+// RPC ID and must not set it.
+func (s *UDPServer) learnFormat(peer *net.UDPAddr, format string, advertised bool) string {
+	s.pmu.Lock()
+	defer s.pmu.Unlock()
+
+	if format == FORMAT_TEXT && advertised {
+		format = FORMAT_BINARY // text sender that understands binary
+	}
+	key := peer.String()
+	if format == FORMAT_BINARY {
+		if _, known := s.peerFormats[key]; !known && len(s.peerFormats) >= MAX_PEER_FORMATS {
+			// Source addresses can be spoofed, so make room rather than grow;
+			// a forgotten peer is only renegotiated over text
+			for k := range s.peerFormats {
+				delete(s.peerFormats, k)
+				break
+			}
+		}
+		s.peerFormats[key] = format
+	} else {
+		delete(s.peerFormats, key) // text is the default
+	}
+
+	if s.format == FORMAT_TEXT {
+		return FORMAT_TEXT
+	}
+	return format
+}
+
+// forgetFormat drops what we know about a peer so the next request
+// falls back to text with a binary advert
+func (s *UDPServer) forgetFormat(peer *net.UDPAddr) {
+	s.pmu.Lock()
+	delete(s.peerFormats, peer.String())
+	s.pmu.Unlock()
+}
+
+// encode renders msg in the given wire format
+func encode(msg Message, format string) string {
+	if format == FORMAT_BINARY {
+		return string(EncodeBinary(msg))
 	}
+	return msg.String()
 }
 
 // RegisterHandler for a specific message type
@@ -148,10 +254,18 @@ func (s *UDPServer) On(msgType string, h Handler) {
 	s.handlers[strings.ToUpper(strings.TrimSpace(msgType))] = h
 }
 
-// WriteTo sends a message to a specific peer
+// WriteTo sends a message to a specific peer.
+// Payloads that would not fit in the receiver's read buffer are refused.
 func (s *UDPServer) WriteTo(peer *net.UDPAddr, payload string) error {
-	_ = s.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
-	_, err := s.conn.WriteToUDP([]byte(payload), peer)
+	if len(payload) > BUFFER_SIZE {
+		return fmt.Errorf("message too large: %d bytes (max %d)", len(payload), BUFFER_SIZE)
+	}
+	conn := s.connection()
+	if conn == nil {
+		return fmt.Errorf("server not started")
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	_, err := conn.WriteToUDP([]byte(payload), peer)
 	return err
 }
 
@@ -164,23 +278,19 @@ func (s *UDPServer) AddWaiter(rpcID string) <-chan Message {
 	return ch
 }
 
-func (s *UDPServer) Wait(rpcID string, timeout time.Duration) (Message, error) {
-	s.wmu.Lock()
-	w, ok := s.waiters[rpcID]
-	s.wmu.Unlock()
-	if !ok {
-		return Message{}, fmt.Errorf("no waiter for rpcID=%s", rpcID)
-	}
-
+// Wait blocks until the reply to rpcID arrives on ch, the channel AddWaiter
+// returned for it, or ctx is done. The waiter is only removed here on
+// cancellation; a delivered reply has already removed it.
+func (s *UDPServer) Wait(ctx context.Context, rpcID string, ch <-chan Message) (Message, error) {
 	select {
-	case msg, ok := <-w.ch:
+	case msg, ok := <-ch:
 		if !ok {
 			return Message{}, fmt.Errorf("waiter closed (server shutting down?)")
 		}
 		return msg, nil
-	case <-time.After(timeout):
+	case <-ctx.Done():
 		s.CancelWaiter(rpcID)
-		return Message{}, fmt.Errorf("timeout waiting for rpcID=%s", rpcID)
+		return Message{}, fmt.Errorf("waiting for rpcID=%s: %w", rpcID, ctx.Err())
 	}
 }
 
@@ -212,42 +322,55 @@ func (s *UDPServer) deliverToWaiter(msg Message) bool {
 	return false
 }
 
-// Ensures msg.RPCID is set, registers waiter, sends, then waits.
-func (s *UDPServer) SendAndWait(peer *net.UDPAddr, msg Message, timeout time.Duration) (Message, error) {
+// Ensures msg.RPCID is set, registers waiter, sends, then waits until the
+// reply arrives or ctx is done.
+func (s *UDPServer) SendAndWait(ctx context.Context, peer *net.UDPAddr, msg Message) (Message, error) {
+	if err := ctx.Err(); err != nil {
+		return Message{}, err
+	}
 	if msg.RPCID == "" {
 		msg.RPCID = util.NewRandomID().Hex()
 	}
+	format, advertise := s.sendFormat(peer)
+	if advertise && !strings.HasPrefix(msg.RPCID, BINARY_ADVERT) {
+		msg.RPCID = BINARY_ADVERT + msg.RPCID
+	}
 	ch := s.AddWaiter(msg.RPCID)
-	_ = ch
 
-	if err := s.WriteTo(peer, msg.String()); err != nil {
+	if err := s.WriteTo(peer, encode(msg, format)); err != nil {
 		s.CancelWaiter(msg.RPCID)
 		return Message{}, err
 	}
-	return s.Wait(msg.RPCID, timeout)
+	reply, err := s.Wait(ctx, msg.RPCID, ch)
+	if err != nil && format == FORMAT_BINARY {
+		// The peer may have been replaced by a text-only node; renegotiate
+		s.forgetFormat(peer)
+	}
+	return reply, err
 }
 
 // Start the server
 func (s *UDPServer) Start() error {
-	conn, err := net.ListenUDP("udp", s.addr)
+	conn, err := net.ListenUDP("udp", s.Addr())
 	if err != nil {
 		panic(err)
 	}
 
+	s.mu.Lock()
 	s.addr = conn.LocalAddr().(*net.UDPAddr)
-	fmt.Println("Starting UDP server on", s.addr.String())
+	s.conn = conn
+	s.mu.Unlock()
+	fmt.Println("Starting UDP server on", conn.LocalAddr().String())
 
 	defer conn.Close()
 
-	s.conn = conn
-
 	buf := make([]byte, BUFFER_SIZE)
 	s.wg.Add(1)
 	defer s.wg.Done()
 
 	for {
 
-		s.conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
+		conn.SetReadDeadline(time.Now().Add(READ_TIMEOUT))
 		n, peer, err := conn.ReadFromUDP(buf)
 		if err != nil {
 			if ne, ok := err.(net.Error); ok && ne.Timeout() {
@@ -264,16 +387,16 @@ func (s *UDPServer) Start() error {
 			continue
 		}
 
-		raw := strings.TrimSpace(string(buf[:n]))
-		msg, perr := ParseMessage(raw)
+		msg, format, perr := DecodePacket(buf[:n])
 		if perr != nil {
 			fmt.Println("UDP parse error:", perr)
 			continue
 		}
-
 		if s.deliverToWaiter(msg) {
+			s.learnFormat(peer, format, false)
 			continue
 		}
+		replyFormat := s.learnFormat(peer, format, strings.HasPrefix(msg.RPCID, BINARY_ADVERT))
 
 		reply, rerr := s.dispatch(peer, msg)
 		if rerr != nil {
@@ -282,7 +405,7 @@ func (s *UDPServer) Start() error {
 		}
 
 		if reply != nil {
-			if err := s.WriteTo(peer, reply.String()); err != nil {
+			if err := s.WriteTo(peer, encode(*reply, replyFormat)); err != nil {
 				fmt.Println("UDP write error:", err)
 			}
 		}
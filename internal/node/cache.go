@@ -0,0 +1,46 @@
+package node
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// cacheOnPath stores a value found by a lookup at the closest queried node
+// that did not have it. The cached copy lives for DefaultTTL halved once for
+// every path node closer to the key, so caches far from the key expire
+// quickly and do not outlive the real replicas.
+func (n *Node) cacheOnPath(keyID util.ID, value []byte, manifest bool, missed []kademlia.Contact, path []kademlia.Contact, timeout time.Duration) {
+	if n.Config.DisablePathCache || len(missed) == 0 {
+		return
+	}
+
+	target := missed[0]
+	target.CalcDistance(&keyID)
+	for _, c := range missed[1:] {
+		c.CalcDistance(&keyID)
+		if c.Less(&target) {
+			target = c
+		}
+	}
+
+	closer := 0
+	for _, c := range path {
+		c.CalcDistance(&keyID)
+		if c.Less(&target) {
+			closer++
+		}
+	}
+	// A zero TTL would ask the target for its default lifetime instead
+	ttl := max(n.Config.DefaultTTL>>closer, time.Second)
+
+	go func() {
+		if err := n.sendStore(n.lifetime, target, keyID.String(), Value{Data: value, Manifest: manifest}, ttl, timeout); err != nil {
+			fmt.Printf("Path cache at %s failed: %v\n", target.Address.String(), err)
+			return
+		}
+		fmt.Printf("Cached %s at %s for %s\n", keyID.String(), target.Address.String(), ttl)
+	}()
+}
@@ -0,0 +1,235 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// CHUNK_SIZE is the largest value stored as a single key. It leaves room for
+// the hex encoding and message header inside one kadnet.BUFFER_SIZE datagram.
+const CHUNK_SIZE = 1024
+
+// CHUNK_PARALLELISM bounds how many chunks are stored or fetched at once.
+const CHUNK_PARALLELISM = 8
+
+// MANIFEST_MAGIC starts every manifest value
+const MANIFEST_MAGIC = "KADMANIFEST1"
+
+// MANIFEST_PREFIX marks store keys holding manifests. Whether a value is a
+// manifest travels next to it, as the MANIFEST_FLAG argument of STORE and
+// VALUE, so content that happens to look like a manifest stays content.
+const MANIFEST_PREFIX = "c:"
+
+// MANIFEST_FLAG is the trailing STORE and VALUE argument marking a manifest
+const MANIFEST_FLAG = "manifest"
+
+// MAX_MANIFEST_DEPTH bounds how many manifest levels Put creates and Get
+// follows, whatever depth a fetched manifest claims.
+const MAX_MANIFEST_DEPTH = 4
+
+// manifest lists the chunks whose concatenation is Size bytes long.
+// At Depth 0 that concatenation is the content itself; at Depth d > 0 it is
+// the encoding of another manifest of depth d-1.
+type manifest struct {
+	Size   int
+	Depth  int
+	Chunks []string // hex content keys, in order
+}
+
+// encode renders the manifest as "MAGIC <size> <depth>\n<key>\n<key>..."
+func (m manifest) encode() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %d %d\n", MANIFEST_MAGIC, m.Size, m.Depth)
+	for _, c := range m.Chunks {
+		b.WriteString(c)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// parseManifest returns the manifest encoded in value, or false if value is
+// not a well-formed manifest.
+func parseManifest(value []byte) (manifest, bool) {
+	lines := strings.Split(strings.TrimRight(string(value), "\n"), "\n")
+	header := strings.Fields(lines[0])
+	if len(header) != 3 || header[0] != MANIFEST_MAGIC {
+		return manifest{}, false
+	}
+	size, err1 := strconv.Atoi(header[1])
+	depth, err2 := strconv.Atoi(header[2])
+	if err1 != nil || err2 != nil || size < 0 || depth < 0 || depth > MAX_MANIFEST_DEPTH {
+		return manifest{}, false
+	}
+
+	m := manifest{Size: size, Depth: depth}
+	for _, line := range lines[1:] {
+		if _, err := util.ParseHexID(line); err != nil {
+			return manifest{}, false
+		}
+		m.Chunks = append(m.Chunks, line)
+	}
+	return m, len(m.Chunks) > 0
+}
+
+// putChunked splits data into CHUNK_SIZE pieces, stores each under its own
+// hash and returns the result of storing the manifest that lists them.
+func (n *Node) putChunked(ctx context.Context, data []byte, depth int) (PutResult, error) {
+	keys, err := n.putChunks(ctx, splitChunks(data))
+	if err != nil {
+		return PutResult{}, err
+	}
+
+	m := manifest{Size: len(data), Depth: depth, Chunks: keys}
+	enc := m.encode()
+	if len(enc) > CHUNK_SIZE {
+		// Too many chunks for one manifest: chunk the manifest itself
+		if depth == MAX_MANIFEST_DEPTH {
+			return PutResult{}, fmt.Errorf("value needs more than %d manifest levels", MAX_MANIFEST_DEPTH)
+		}
+		return n.putChunked(ctx, enc, depth+1)
+	}
+	fmt.Printf("Stored %d chunk(s) under manifest depth %d\n", len(keys), depth)
+	return n.putValue(ctx, enc, true)
+}
+
+// loadValue returns the value held under keyHex and whether it is a manifest
+func (n *Node) loadValue(keyHex string) ([]byte, bool, bool) {
+	if val, ok := n.loadLocal(keyHex); ok {
+		return val, false, true
+	}
+	val, ok := n.loadLocal(MANIFEST_PREFIX + keyHex)
+	return val, true, ok
+}
+
+// putChunks stores every chunk in parallel and returns their keys in order.
+func (n *Node) putChunks(ctx context.Context, chunks [][]byte) ([]string, error) {
+	keys := make([]string, len(chunks))
+	errs := make([]error, len(chunks))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, CHUNK_PARALLELISM)
+	for i, chunk := range chunks {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			res, err := n.putValue(ctx, chunk, false)
+			keys[i], errs[i] = hex.EncodeToString(res.Key), err
+		})
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("store chunk %d: %w", i, err)
+		}
+	}
+	return keys, nil
+}
+
+// splitChunks cuts data into CHUNK_SIZE pieces; the last one may be shorter
+func splitChunks(data []byte) [][]byte {
+	chunks := make([][]byte, 0, (len(data)+CHUNK_SIZE-1)/CHUNK_SIZE)
+	for len(data) > CHUNK_SIZE {
+		chunks = append(chunks, data[:CHUNK_SIZE])
+		data = data[CHUNK_SIZE:]
+	}
+	return append(chunks, data)
+}
+
+// Get looks up keyID and, if it was stored as a manifest, fetches and
+// reassembles the chunks it lists. From is the node that served the
+// top-level value; Rejected collects every responder, for the manifest or
+// any chunk, that served content not matching its key. The whole fetch is
+// abandoned once ctx is done.
+func (n *Node) Get(ctx context.Context, keyID util.ID, perNodeTimeout time.Duration) (ValueResult, error) {
+	ctx, cancel := n.opContext(ctx)
+	defer cancel()
+
+	res, err := n.FindValue(ctx, keyID, perNodeTimeout)
+	if err != nil {
+		return res, err
+	}
+
+	if !res.Manifest {
+		return res, nil
+	}
+	m, ok := parseManifest(res.Value)
+	if !ok {
+		return res, fmt.Errorf("manifest for %s is corrupt", keyID)
+	}
+	for {
+		content, rejected, err := n.fetchChunks(ctx, m, perNodeTimeout)
+		res.Rejected = append(res.Rejected, rejected...)
+		if err != nil {
+			return res, err
+		}
+		res.Value = content
+		if m.Depth == 0 {
+			res.Manifest = false
+			return res, nil
+		}
+		// Each level down must be exactly one shallower, so this ends
+		next, ok := parseManifest(content)
+		if !ok || next.Depth != m.Depth-1 {
+			return res, fmt.Errorf("manifest for %s is corrupt", keyID)
+		}
+		m = next
+	}
+}
+
+// fetchChunks retrieves every chunk of m in parallel and concatenates them.
+func (n *Node) fetchChunks(ctx context.Context, m manifest, perNodeTimeout time.Duration) ([]byte, []kademlia.Contact, error) {
+	parts := make([][]byte, len(m.Chunks))
+	rejects := make([][]kademlia.Contact, len(m.Chunks))
+	errs := make([]error, len(m.Chunks))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, CHUNK_PARALLELISM)
+	for i, keyHex := range m.Chunks {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			parts[i], rejects[i], errs[i] = n.fetchChunk(ctx, keyHex, perNodeTimeout)
+		})
+	}
+	wg.Wait()
+
+	var rejected []kademlia.Contact
+	for _, r := range rejects {
+		rejected = append(rejected, r...)
+	}
+	for i, err := range errs {
+		if err != nil {
+			return nil, rejected, fmt.Errorf("fetch chunk %d (%s): %w", i, m.Chunks[i], err)
+		}
+	}
+	content := bytes.Join(parts, nil)
+	if len(content) != m.Size {
+		return nil, rejected, fmt.Errorf("reassembled %d bytes, manifest says %d", len(content), m.Size)
+	}
+	return content, rejected, nil
+}
+
+// fetchChunk returns the chunk stored under keyHex, preferring a local copy.
+func (n *Node) fetchChunk(ctx context.Context, keyHex string, perNodeTimeout time.Duration) ([]byte, []kademlia.Contact, error) {
+	if val, ok := n.loadLocal(keyHex); ok {
+		return val, nil, nil
+	}
+	keyID, err := util.ParseHexID(keyHex)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := n.FindValue(ctx, keyID, perNodeTimeout)
+	return res.Value, res.Rejected, err
+}
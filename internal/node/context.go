@@ -0,0 +1,27 @@
+package node
+
+import (
+	"context"
+	"time"
+)
+
+// OPERATION_TIMEOUT bounds a node operation, such as a lookup or a Put,
+// whose context carries no deadline of its own.
+const OPERATION_TIMEOUT = 30 * time.Second
+
+// opContext derives the context an operation runs under from ctx. It gets
+// Config.OperationTimeout as its deadline unless ctx already has one, and is
+// cancelled when the node shuts down.
+func (n *Node) opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if _, ok := ctx.Deadline(); ok {
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, n.Config.OperationTimeout)
+	}
+	stop := context.AfterFunc(n.lifetime, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
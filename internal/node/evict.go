@@ -0,0 +1,67 @@
+package node
+
+import (
+	"fmt"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+)
+
+// EVICTION_QUEUE_SIZE bounds how many eviction checks may wait at once.
+// Further checks are dropped; their newcomers stay in the replacement cache
+// and get another chance the next time the bucket overflows.
+const EVICTION_QUEUE_SIZE = 64
+
+// EVICTION_WORKERS is how many eviction pings may be in flight at once.
+const EVICTION_WORKERS = 4
+
+// queueEvictionCheck schedules a liveness ping of a full bucket's least
+// recently seen member without blocking. A member already queued or being
+// pinged is not queued twice.
+func (n *Node) queueEvictionCheck(c kademlia.Contact) {
+	key := c.ID.String()
+
+	n.evictMu.Lock()
+	defer n.evictMu.Unlock()
+	if n.evictPending[key] {
+		return
+	}
+	select {
+	case n.evictQueue <- c:
+		n.evictPending[key] = true
+	default:
+		fmt.Printf("Eviction queue full, skipping check of %s\n", c.Address.String())
+	}
+}
+
+// runEvictor works through queued eviction checks until the node shuts down.
+func (n *Node) runEvictor() {
+	for {
+		select {
+		case c := <-n.evictQueue:
+			n.checkEviction(c)
+
+			n.evictMu.Lock()
+			delete(n.evictPending, c.ID.String())
+			n.evictMu.Unlock()
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+// checkEviction pings c. A dead member is replaced by the most recent
+// candidate in its bucket's replacement cache; a live one moves to the
+// front of its bucket.
+func (n *Node) checkEviction(c kademlia.Contact) {
+	_, err := n.PingSync(n.lifetime, &c.Address, ADAPTIVE_TIMEOUT)
+	if err != nil && n.lifetime.Err() != nil {
+		return // shutting down, not evidence that c is dead
+	}
+	if err != nil {
+		n.RoutingTable.RemoveContact(c)
+		n.noteRemoval()
+		fmt.Printf("PING -> %s failed: %v\n", c.Address.String(), err)
+		return
+	}
+	n.RoutingTable.AddContact(c)
+}
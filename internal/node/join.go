@@ -0,0 +1,173 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+)
+
+// JOIN_RETRY_MIN is how long a node waits after its first failed join
+// before trying again; every further failure doubles the wait, up to
+// JOIN_RETRY_MAX.
+const (
+	JOIN_RETRY_MIN = 500 * time.Millisecond
+	JOIN_RETRY_MAX = 30 * time.Second
+)
+
+// ErrNotJoined is returned by JoinNetwork when no peer answered
+var ErrNotJoined = errors.New("no peer answered")
+
+// Ready returns a channel closed once the node has joined the network,
+// which CreateNode starts doing in the background. A node without peers to
+// join through is ready as soon as it is created, or once it has tried to
+// reseed its routing table if it has a snapshot.
+func (n *Node) Ready() <-chan struct{} {
+	return n.ready
+}
+
+// markReady closes the Ready channel, once
+func (n *Node) markReady() {
+	n.readyOnce.Do(func() { close(n.ready) })
+}
+
+// JoinNetwork pings every address the configured peers resolve to and
+// looks up our own ID through the ones that answer, giving up once ctx is
+// done. It returns ErrNotJoined if no peer, nor any contact from the last
+// snapshot, answered.
+func (n *Node) JoinNetwork(ctx context.Context) error {
+	fmt.Println("Joining network...")
+	ctx, cancel := n.opContext(ctx)
+	defer cancel()
+
+	// Contacts saved before a restart let us rejoin without any live peer
+	reseeded := n.warmStart(ctx)
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		joined bool
+	)
+	for _, addr := range n.resolvePeers(ctx) {
+		wg.Go(func() {
+			peerID, err := n.PingSync(ctx, addr, 0)
+			if err != nil {
+				fmt.Printf("PING -> %s failed: %v\n", addr.String(), err)
+				return
+			}
+			fmt.Printf("Peer %s is alive (id=%s)\n", addr.String(), peerID.String())
+
+			// Add bootstrap peer explicitly to routing table
+			n.AddContact(kademlia.NewContactWithDistance(&n.ID, addr, &peerID))
+			mu.Lock()
+			joined = true
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("join aborted: %w", err)
+	}
+	if !joined && reseeded == 0 {
+		return ErrNotJoined
+	}
+
+	contacts := n.IterativeFindNode(ctx, n.ID, 0)
+	if joined {
+		for _, c := range contacts {
+			fmt.Printf("Found contact: %s\n", c.String())
+		}
+	} else {
+		fmt.Printf("Rejoined through snapshot, found %d contact(s)\n", len(contacts))
+	}
+	n.markReady()
+	return nil
+}
+
+// resolvePeers returns every UDP address the configured peers resolve to.
+// A peer named by a host with several addresses, such as a scaled
+// docker-compose service, yields each of them.
+func (n *Node) resolvePeers(ctx context.Context) []*net.UDPAddr {
+	var addrs []*net.UDPAddr
+	for _, peer := range n.Config.Peers {
+		host, portStr, err := net.SplitHostPort(peer)
+		if err != nil {
+			fmt.Printf("Resolve %s: %v\n", peer, err)
+			continue
+		}
+		port, err := net.DefaultResolver.LookupPort(ctx, "udp", portStr)
+		if err != nil {
+			fmt.Printf("Resolve %s: %v\n", peer, err)
+			continue
+		}
+		ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			fmt.Printf("Resolve %s: %v\n", peer, err)
+			continue
+		}
+		for _, ip := range ips {
+			addrs = append(addrs, net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip.Unmap(), uint16(port))))
+		}
+	}
+	return addrs
+}
+
+// runJoiner joins the network and keeps the node in it until it shuts
+// down. After a failed join it retries with exponential backoff; once
+// joined it rejoins whenever the routing table empties.
+func (n *Node) runJoiner() {
+	wait := n.Config.JoinRetryMin
+	joined := n.JoinNetwork(n.lifetime) == nil
+	if len(n.Config.Peers) == 0 {
+		// Only a snapshot to join through; others join through us either way
+		n.markReady()
+	}
+	for {
+		if joined {
+			select {
+			case <-n.rejoin:
+			case <-n.quit:
+				return
+			}
+			if !n.isolated() {
+				continue // refilled in the meantime
+			}
+			fmt.Println("Routing table is empty, rejoining network...")
+			wait = n.Config.JoinRetryMin
+		} else {
+			// Jitter keeps nodes started together from retrying in step
+			delay := wait + rand.N(wait/2+1)
+			fmt.Printf("Join failed, retrying in %s\n", delay.Round(time.Millisecond))
+			select {
+			case <-time.After(delay):
+			case <-n.quit:
+				return
+			}
+			wait = min(2*wait, n.Config.JoinRetryMax)
+		}
+		joined = n.JoinNetwork(n.lifetime) == nil
+	}
+}
+
+// isolated reports whether the routing table is empty
+func (n *Node) isolated() bool {
+	return len(n.RoutingTable.FindClosestContacts(&n.ID, 1)) == 0
+}
+
+// noteRemoval wakes the joiner if removing a contact left the table empty
+func (n *Node) noteRemoval() {
+	if !n.isolated() {
+		return
+	}
+	select {
+	case n.rejoin <- struct{}{}:
+	default: // a rejoin is already pending
+	}
+}
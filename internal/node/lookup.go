@@ -0,0 +1,186 @@
+package node
+
+import (
+	"context"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// lookup is the state of one iterative lookup. It keeps up to Config.Alpha
+// queries in flight, starting another as soon as one completes, and ends
+// once the Config.Replication closest contacts seen have all replied, no
+// candidate is left to ask or its context is done. A contact whose query
+// fails is dropped.
+type lookup struct {
+	n      *Node
+	kind   string // the RPC it sends, for tracing
+	target util.ID
+
+	// shortlist holds every live contact seen, closest to target first
+	shortlist []kademlia.Contact
+	seen      map[string]bool // in the shortlist or dropped from it
+	queried   map[string]bool
+	replied   map[string]bool
+	hops      map[string]int // replies between our table and each contact
+	sent      int
+	trace     *LookupTrace // nil unless run under WithTrace
+}
+
+// lookupReply is what one queried contact answered
+type lookupReply struct {
+	from     kademlia.Contact
+	contacts []kademlia.Contact
+	value    []byte // FIND_VALUE only
+	manifest bool
+	found    bool
+	err      error
+	rtt      time.Duration
+}
+
+// newLookup starts a lookup sending kind RPCs for target from our routing
+// table
+func (n *Node) newLookup(kind string, target util.ID) *lookup {
+	n.RoutingTable.Touch(&target)
+	l := &lookup{
+		n:       n,
+		kind:    kind,
+		target:  target,
+		seen:    make(map[string]bool),
+		queried: make(map[string]bool),
+		replied: make(map[string]bool),
+		hops:    make(map[string]int),
+	}
+	for _, c := range n.RoutingTable.FindClosestContacts(&target, n.Config.Replication) {
+		l.shortlist = append(l.shortlist, c)
+		l.seen[c.ID.String()] = true
+	}
+	return l
+}
+
+// run drives the lookup until ctx is done, sending query to each contact
+// picked. handle sees every reply before its contacts are merged and ends
+// the lookup by returning true; it may be nil. Queries still in flight when
+// run returns are cancelled. Under WithTrace every query is recorded.
+func (l *lookup) run(ctx context.Context, query func(context.Context, kademlia.Contact) lookupReply, handle func(lookupReply) bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	l.trace = traceFrom(ctx).begin(l.kind, l.target)
+	result := "converged"
+	defer func() { l.trace.finish(result, l.closest()) }()
+
+	// At most Alpha replies are ever outstanding, so senders never block
+	// on a lookup that has already returned
+	replies := make(chan lookupReply, l.n.Config.Alpha)
+	inflight := 0
+
+	for !l.converged() {
+		for _, c := range l.n.nextBatch(l.closest(), l.queried, l.target, l.n.Config.Alpha-inflight) {
+			inflight++
+			l.sent++
+			l.trace.sent(l.hops[c.ID.String()]+1, c, l.target)
+			go func(c kademlia.Contact) {
+				start := time.Now()
+				r := query(ctx, c)
+				r.from, r.rtt = c, time.Since(start)
+				replies <- r
+			}(c)
+		}
+		if inflight == 0 {
+			result = "exhausted" // nobody left to ask
+			return
+		}
+
+		var r lookupReply
+		select {
+		case r = <-replies:
+		case <-ctx.Done():
+			result = ctx.Err().Error()
+			return
+		}
+		inflight--
+		if handle != nil && handle(r) {
+			l.trace.replied(r, nil, l.best(), l.target)
+			result = "found"
+			return
+		}
+		if r.err != nil {
+			l.drop(r.from)
+			l.trace.replied(r, nil, l.best(), l.target)
+			continue
+		}
+		l.replied[r.from.ID.String()] = true
+		learned := l.merge(r.from, r.contacts)
+		l.trace.replied(r, learned, l.best(), l.target)
+	}
+}
+
+// closest returns the Config.Replication closest live contacts seen
+func (l *lookup) closest() []kademlia.Contact {
+	if len(l.shortlist) > l.n.Config.Replication {
+		return l.shortlist[:l.n.Config.Replication]
+	}
+	return l.shortlist
+}
+
+// converged reports whether the closest contacts seen have all replied
+func (l *lookup) converged() bool {
+	closest := l.closest()
+	for _, c := range closest {
+		if !l.replied[c.ID.String()] {
+			return false
+		}
+	}
+	return len(closest) > 0
+}
+
+// best returns the closest live contact seen, or nil
+func (l *lookup) best() *kademlia.Contact {
+	if len(l.shortlist) == 0 {
+		return nil
+	}
+	return &l.shortlist[0]
+}
+
+// merge adds the contacts named in from's reply to the routing table and
+// the shortlist, keeping the shortlist ordered by distance. It returns the
+// contacts the lookup had not seen before.
+func (l *lookup) merge(from kademlia.Contact, contacts []kademlia.Contact) []kademlia.Contact {
+	var learned []kademlia.Contact
+	for _, c := range contacts {
+		c.CalcDistance(&l.target)
+		l.n.AddContact(c) // maintain table
+		if id := c.ID.String(); !l.seen[id] {
+			l.seen[id] = true
+			l.shortlist = append(l.shortlist, c)
+			l.hops[id] = l.hops[from.ID.String()] + 1
+			learned = append(learned, c)
+		}
+	}
+	cand := kademlia.ContactCandidates{}
+	cand.Append(l.shortlist)
+	cand.Sort()
+	l.shortlist = cand.GetContacts(cand.Len())
+	return learned
+}
+
+// drop removes a contact that failed its query from the shortlist
+func (l *lookup) drop(c kademlia.Contact) {
+	for i := range l.shortlist {
+		if l.shortlist[i].ID.Equals(c.ID) {
+			l.shortlist = append(l.shortlist[:i], l.shortlist[i+1:]...)
+			return
+		}
+	}
+}
+
+// result returns the closest contacts found and how far the lookup went
+func (l *lookup) result() LookupResult {
+	res := LookupResult{Contacts: append([]kademlia.Contact(nil), l.closest()...), Queried: l.sent}
+	if len(res.Contacts) > 0 {
+		res.Hops = l.hops[res.Contacts[0].ID.String()]
+	}
+	return res
+}
@@ -1,9 +1,14 @@
 package node
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
@@ -11,12 +16,48 @@ import (
 	"github.com/t0sic/D7024E-Kademlia/internal/util"
 )
 
-func (n *Node) PingSync(addr *net.UDPAddr, timeout time.Duration) (util.ID, error) {
+// sendAndWait is Server.SendAndWait plus liveness tracking: the RTT
+// estimate for addr is updated on a reply and backed off if it timed out,
+// and the contact at addr, if any, has a failure counted, which drops it
+// from the routing table after kademlia.MAX_FAILURES in a row. The wait
+// ends after timeout, or the peer's adaptive RTO if it is zero, or earlier
+// once ctx is done; an RPC abandoned that way, or one that failed on our
+// side or with a bad reply, counts against nobody.
+func (n *Node) sendAndWait(ctx context.Context, addr *net.UDPAddr, msg kadnet.Message, timeout time.Duration) (kadnet.Message, error) {
+	if timeout <= 0 {
+		timeout = n.RTO(addr)
+	}
+	rpcCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := n.Server.SendAndWait(rpcCtx, addr, n.tagParams(msg))
+	if err == nil {
+		resp, err = n.checkParams(resp)
+	}
+	if err != nil {
+		// Only silence within the timeout we allowed says the peer is gone
+		if ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+			return resp, err
+		}
+		n.recordTimeout(addr)
+		if n.RoutingTable.RecordFailure(addr) {
+			fmt.Printf("Dropped %s after %d failed RPCs\n", addr.String(), kademlia.MAX_FAILURES)
+			n.noteRemoval()
+		}
+		return resp, err
+	}
+	n.recordRTT(addr, time.Since(start))
+	n.RoutingTable.RecordReply(addr)
+	return resp, nil
+}
+
+func (n *Node) PingSync(ctx context.Context, addr *net.UDPAddr, timeout time.Duration) (util.ID, error) {
 	req := kadnet.Message{
 		Type: kadnet.MSG_PING,
 		Args: []string{n.ID.String()},
 	}
-	resp, err := n.Server.SendAndWait(addr, req, timeout)
+	resp, err := n.sendAndWait(ctx, addr, req, timeout)
 	if err != nil {
 		return util.ID{}, err
 	}
@@ -30,18 +71,23 @@ func (n *Node) PingSync(addr *net.UDPAddr, timeout time.Duration) (util.ID, erro
 	return peerID, nil
 }
 
-func (n *Node) FindNodesSync(addr *net.UDPAddr, fromID, target util.ID, timeout time.Duration) ([]kademlia.Contact, error) {
+func (n *Node) FindNodesSync(ctx context.Context, addr *net.UDPAddr, fromID, target util.ID, timeout time.Duration) ([]kademlia.Contact, error) {
 	req := kadnet.Message{
 		Type: kadnet.MSG_FIND_NODE,
 		Args: []string{fromID.String(), target.String()},
 	}
-	resp, err := n.Server.SendAndWait(addr, req, timeout)
+	resp, err := n.sendAndWait(ctx, addr, req, timeout)
 	if err != nil {
 		return nil, err
 	}
 	if resp.Type != kadnet.MSG_NODES || len(resp.Args) < 1 {
 		return nil, fmt.Errorf("bad NODES response")
 	}
+	return decodeNodes(resp, target), nil
+}
+
+// decodeNodes returns the contacts in a NODES reply, with distances to target
+func decodeNodes(resp kadnet.Message, target util.ID) []kademlia.Contact {
 	// resp.Args: [responderID, id@host:port, ...]
 	contacts := make([]kademlia.Contact, 0, len(resp.Args)-1)
 	tgt := target // distance relative to lookup target
@@ -50,10 +96,66 @@ func (n *Node) FindNodesSync(addr *net.UDPAddr, fromID, target util.ID, timeout
 			contacts = append(contacts, *c)
 		}
 	}
-	return contacts, nil
+	return contacts
 }
 
-func (n *Node) SendGetSync(to kademlia.Contact, keyHex string, timeout time.Duration) ([]byte, bool, error) {
+// Value is the content of a VALUE reply
+type Value struct {
+	Data []byte
+	// Manifest is set if the value was stored as a chunk manifest
+	Manifest bool
+}
+
+// SendFindValueSync asks a contact for the value under keyID. It returns the
+// value if the contact holds it, else the closer contacts it knows. Like
+// SendGetSync, a value not hashing to the key is reported as ErrHashMismatch.
+func (n *Node) SendFindValueSync(ctx context.Context, to kademlia.Contact, keyID util.ID, timeout time.Duration) (Value, bool, []kademlia.Contact, error) {
+	keyHex := keyID.String()
+	req := kadnet.Message{
+		Type: kadnet.MSG_FIND_VALUE,
+		Args: []string{n.ID.String(), keyHex},
+	}
+	resp, err := n.sendAndWait(ctx, &to.Address, req, timeout)
+	if err != nil {
+		return Value{}, false, nil, err
+	}
+
+	switch resp.Type {
+	case kadnet.MSG_VALUE:
+		val, err := decodeValue(to, keyHex, resp)
+		return val, err == nil, nil, err
+	case kadnet.MSG_NODES:
+		if len(resp.Args) < 1 {
+			return Value{}, false, nil, fmt.Errorf("bad NODES response")
+		}
+		return Value{}, false, decodeNodes(resp, keyID), nil
+	default:
+		return Value{}, false, nil, fmt.Errorf("unexpected response type %q", resp.Type)
+	}
+}
+
+// decodeValue returns the value in a VALUE reply after checking its hash
+func decodeValue(to kademlia.Contact, keyHex string, resp kadnet.Message) (Value, error) {
+	if len(resp.Args) < 3 {
+		return Value{}, fmt.Errorf("VALUE malformed response")
+	}
+	b, err := hex.DecodeString(resp.Args[2])
+	if err != nil {
+		return Value{}, fmt.Errorf("VALUE not hex: %w", err)
+	}
+	if util.HashID(b, len(keyHex)/2).String() != keyHex {
+		return Value{}, fmt.Errorf("%w: from %s", ErrHashMismatch, to.Address.String())
+	}
+	return Value{Data: b, Manifest: len(resp.Args) > 3 && resp.Args[3] == MANIFEST_FLAG}, nil
+}
+
+// ErrHashMismatch is returned when a VALUE does not hash to the requested key
+var ErrHashMismatch = errors.New("value does not hash to the requested key")
+
+// SendGetSync asks a contact for the value under keyHex. A VALUE whose
+// util.HashID at the key's width differs from the key is reported as
+// ErrHashMismatch rather than returned.
+func (n *Node) SendGetSync(ctx context.Context, to kademlia.Contact, keyHex string, timeout time.Duration) (Value, bool, error) {
 	req := kadnet.Message{
 		Type: kadnet.MSG_GET,
 		Args: []string{
@@ -61,31 +163,34 @@ func (n *Node) SendGetSync(to kademlia.Contact, keyHex string, timeout time.Dura
 			keyHex,
 		},
 	}
-	resp, err := n.Server.SendAndWait(&to.Address, req, timeout)
+	resp, err := n.sendAndWait(ctx, &to.Address, req, timeout)
 	if err != nil {
-		return nil, false, err
+		return Value{}, false, err
 	}
 
 	switch resp.Type {
 	case kadnet.MSG_VALUE:
-		if len(resp.Args) < 3 {
-			return nil, false, fmt.Errorf("VALUE malformed response")
-		}
-		valHex := resp.Args[2]
-		b, err := hex.DecodeString(valHex)
+		val, err := decodeValue(to, keyHex, resp)
 		if err != nil {
-			return nil, false, fmt.Errorf("VALUE not hex: %w", err)
+			return Value{}, false, err
 		}
-		return b, true, nil
+		return val, true, nil
 	case kadnet.MSG_NOT_FOUND:
-		return nil, false, nil
+		return Value{}, false, nil
 	default:
-		return nil, false, fmt.Errorf("unexpected response type %q", resp.Type)
+		return Value{}, false, fmt.Errorf("unexpected response type %q", resp.Type)
 	}
 }
 
-func (n *Node) SendStoreSync(to kademlia.Contact, keyHex string, value []byte, timeout time.Duration) error {
-	valHex := hex.EncodeToString(value)
+// SendStoreSync asks a contact to store value under keyHex for ttl.
+// A zero ttl leaves the lifetime up to the receiving node.
+func (n *Node) SendStoreSync(ctx context.Context, to kademlia.Contact, keyHex string, value []byte, ttl time.Duration, timeout time.Duration) error {
+	return n.sendStore(ctx, to, keyHex, Value{Data: value}, ttl, timeout)
+}
+
+// sendStore is SendStoreSync for a value that may be a manifest
+func (n *Node) sendStore(ctx context.Context, to kademlia.Contact, keyHex string, value Value, ttl time.Duration, timeout time.Duration) error {
+	valHex := hex.EncodeToString(value.Data)
 	msg := kadnet.Message{
 		Type: kadnet.MSG_STORE,
 		Args: []string{
@@ -94,7 +199,93 @@ func (n *Node) SendStoreSync(to kademlia.Contact, keyHex string, value []byte, t
 			valHex,
 		},
 	}
+	if ttl > 0 || value.Manifest {
+		// round up so sub-second TTLs are not sent as "use the default"
+		secs := int64((ttl + time.Second - 1) / time.Second)
+		msg.Args = append(msg.Args, strconv.FormatInt(secs, 10))
+	}
+	if value.Manifest {
+		msg.Args = append(msg.Args, MANIFEST_FLAG)
+	}
+
+	resp, err := n.sendAndWait(ctx, &to.Address, msg, timeout)
+	if err != nil {
+		return err
+	}
+	return checkStored(to, keyHex, resp)
+}
+
+// checkStored accepts a STORED ack for keyHex and turns a STORE_REFUSED
+// reply into an ErrStoreRefused error
+func checkStored(to kademlia.Contact, keyHex string, resp kadnet.Message) error {
+	switch resp.Type {
+	case kadnet.MSG_STORED:
+		if len(resp.Args) < 2 || resp.Args[1] != keyHex {
+			return fmt.Errorf("STORED from %s acknowledges %v, want key %s", to.Address.String(), resp.Args, keyHex)
+		}
+		return nil
+	case kadnet.MSG_STORE_REFUSED:
+		reason := "unknown"
+		if len(resp.Args) > 2 {
+			reason = resp.Args[2]
+		}
+		return fmt.Errorf("%w by %s: %s", ErrStoreRefused, to.Address.String(), reason)
+	default:
+		return fmt.Errorf("unexpected response type %q", resp.Type)
+	}
+}
 
-	_, err := n.Server.SendAndWait(&to.Address, msg, timeout)
-	return err
+// SendPutMutableSync asks a contact to store a signed mutable record for ttl.
+func (n *Node) SendPutMutableSync(ctx context.Context, to kademlia.Contact, rec MutableRecord, ttl time.Duration, timeout time.Duration) error {
+	msg := kadnet.Message{
+		Type: kadnet.MSG_PUT_MUTABLE,
+		Args: append([]string{n.ID.String()}, mutableArgs(rec)...),
+	}
+	if ttl > 0 {
+		secs := int64((ttl + time.Second - 1) / time.Second)
+		msg.Args = append(msg.Args, strconv.FormatInt(secs, 10))
+	}
+
+	resp, err := n.sendAndWait(ctx, &to.Address, msg, timeout)
+	if err != nil {
+		return err
+	}
+	return checkStored(to, rec.Target(n.ID.Len()).String(), resp)
+}
+
+// SendGetMutableSync asks a contact for the record published under pub.
+// A record for another key or with a bad signature is reported as
+// ErrBadSignature rather than returned.
+func (n *Node) SendGetMutableSync(ctx context.Context, to kademlia.Contact, pub ed25519.PublicKey, timeout time.Duration) (MutableRecord, bool, error) {
+	target := MutableTarget(pub, n.ID.Len())
+	req := kadnet.Message{
+		Type: kadnet.MSG_GET_MUTABLE,
+		Args: []string{n.ID.String(), target.String()},
+	}
+	resp, err := n.sendAndWait(ctx, &to.Address, req, timeout)
+	if err != nil {
+		return MutableRecord{}, false, err
+	}
+
+	switch resp.Type {
+	case kadnet.MSG_MUTABLE_VALUE:
+		if len(resp.Args) < 5 {
+			return MutableRecord{}, false, fmt.Errorf("MUTABLE_VALUE malformed response")
+		}
+		rec, err := parseMutableArgs(resp.Args[1:5])
+		if err != nil {
+			return MutableRecord{}, false, fmt.Errorf("MUTABLE_VALUE %w", err)
+		}
+		if !bytes.Equal(rec.PublicKey, pub) {
+			return MutableRecord{}, false, fmt.Errorf("%w: wrong public key from %s", ErrBadSignature, to.Address.String())
+		}
+		if err := rec.Verify(); err != nil {
+			return MutableRecord{}, false, fmt.Errorf("%w (from %s)", err, to.Address.String())
+		}
+		return rec, true, nil
+	case kadnet.MSG_NOT_FOUND:
+		return MutableRecord{}, false, nil
+	default:
+		return MutableRecord{}, false, fmt.Errorf("unexpected response type %q", resp.Type)
+	}
 }
@@ -0,0 +1,383 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/storage"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// MUTABLE_PREFIX marks store keys holding signed mutable records, keeping
+// them apart from content-addressed values under the same hex key.
+const MUTABLE_PREFIX = "m:"
+
+// MUTABLE_MAX_VALUE is the largest value a mutable record may carry. Records
+// are not chunked, so it must fit in one datagram.
+const MUTABLE_MAX_VALUE = CHUNK_SIZE
+
+// MUTABLE_SIGN_PREFIX starts the bytes that a record signature covers
+const MUTABLE_SIGN_PREFIX = "kadmut1"
+
+var (
+	// ErrBadSignature is returned for records that do not verify
+	ErrBadSignature = errors.New("mutable record signature invalid")
+	// ErrStaleSeq is returned when a node already holds a newer record
+	ErrStaleSeq = errors.New("mutable record sequence number is not newer")
+	// ErrNoMutableRecord is returned by GetMutable when the nodes asked
+	// answered but none held a record
+	ErrNoMutableRecord = errors.New("no mutable record")
+)
+
+// MutableRecord is a value signed by an ed25519 key. It is stored under
+// MutableTarget(PublicKey) and replaced only by a record with a higher Seq.
+type MutableRecord struct {
+	PublicKey ed25519.PublicKey
+	Seq       uint64
+	Value     []byte
+	Signature []byte
+}
+
+// MutableTarget returns the key a public key's records are stored under in
+// a network of idBytes-wide IDs
+func MutableTarget(pub ed25519.PublicKey, idBytes int) util.ID {
+	return util.HashID(pub, idBytes)
+}
+
+// SignMutable builds a record for value at sequence number seq
+func SignMutable(priv ed25519.PrivateKey, seq uint64, value []byte) MutableRecord {
+	pub := priv.Public().(ed25519.PublicKey)
+	return MutableRecord{
+		PublicKey: pub,
+		Seq:       seq,
+		Value:     value,
+		Signature: ed25519.Sign(priv, signedBytes(seq, value)),
+	}
+}
+
+// signedBytes is MUTABLE_SIGN_PREFIX, the big-endian seq and the value
+func signedBytes(seq uint64, value []byte) []byte {
+	b := append([]byte(MUTABLE_SIGN_PREFIX), binary.BigEndian.AppendUint64(nil, seq)...)
+	return append(b, value...)
+}
+
+// Verify checks the record's size limits and signature
+func (r MutableRecord) Verify() error {
+	if len(r.PublicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("%w: public key is %d bytes", ErrBadSignature, len(r.PublicKey))
+	}
+	if len(r.Value) > MUTABLE_MAX_VALUE {
+		return fmt.Errorf("mutable value is %d bytes, max %d", len(r.Value), MUTABLE_MAX_VALUE)
+	}
+	if !ed25519.Verify(r.PublicKey, signedBytes(r.Seq, r.Value), r.Signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Target returns the key the record is stored under in a network of
+// idBytes-wide IDs
+func (r MutableRecord) Target(idBytes int) util.ID { return MutableTarget(r.PublicKey, idBytes) }
+
+// encode lays the record out as pubkey(32) seq(8) signature(64) value
+func (r MutableRecord) encode() []byte {
+	b := append([]byte{}, r.PublicKey...)
+	b = binary.BigEndian.AppendUint64(b, r.Seq)
+	b = append(b, r.Signature...)
+	return append(b, r.Value...)
+}
+
+func decodeMutable(b []byte) (MutableRecord, error) {
+	const header = ed25519.PublicKeySize + 8 + ed25519.SignatureSize
+	if len(b) < header {
+		return MutableRecord{}, fmt.Errorf("mutable record truncated")
+	}
+	return MutableRecord{
+		PublicKey: ed25519.PublicKey(b[:ed25519.PublicKeySize]),
+		Seq:       binary.BigEndian.Uint64(b[ed25519.PublicKeySize:]),
+		Signature: b[ed25519.PublicKeySize+8 : header],
+		Value:     b[header:],
+	}, nil
+}
+
+// mutableArgs renders the record as <pubHex> <seq> <valueHex> <sigHex>
+func mutableArgs(r MutableRecord) []string {
+	return []string{
+		hex.EncodeToString(r.PublicKey),
+		strconv.FormatUint(r.Seq, 10),
+		hex.EncodeToString(r.Value),
+		hex.EncodeToString(r.Signature),
+	}
+}
+
+// parseMutableArgs is the inverse of mutableArgs
+func parseMutableArgs(args []string) (MutableRecord, error) {
+	if len(args) < 4 {
+		return MutableRecord{}, fmt.Errorf("want <pubHex> <seq> <valueHex> <sigHex>")
+	}
+	pub, err := hex.DecodeString(args[0])
+	if err != nil {
+		return MutableRecord{}, fmt.Errorf("public key not hex: %w", err)
+	}
+	seq, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		return MutableRecord{}, fmt.Errorf("bad seq %q", args[1])
+	}
+	value, err := hex.DecodeString(args[2])
+	if err != nil {
+		return MutableRecord{}, fmt.Errorf("value not hex: %w", err)
+	}
+	sig, err := hex.DecodeString(args[3])
+	if err != nil {
+		return MutableRecord{}, fmt.Errorf("signature not hex: %w", err)
+	}
+	return MutableRecord{PublicKey: pub, Seq: seq, Value: value, Signature: sig}, nil
+}
+
+// storeMutableLocal verifies rec and saves it unless a record with a higher
+// seq is already held. An equal seq only refreshes the TTL of the same record.
+func (n *Node) storeMutableLocal(rec MutableRecord, ttl time.Duration, publisher string) error {
+	if err := rec.Verify(); err != nil {
+		return err
+	}
+	key := MUTABLE_PREFIX + rec.Target(n.ID.Len()).String()
+
+	n.mutableMu.Lock()
+	defer n.mutableMu.Unlock()
+
+	if cur, ok := n.loadMutableLocal(rec.Target(n.ID.Len())); ok {
+		if cur.Seq > rec.Seq || (cur.Seq == rec.Seq && !bytes.Equal(cur.encode(), rec.encode())) {
+			return fmt.Errorf("%w: holding seq %d, got %d", ErrStaleSeq, cur.Seq, rec.Seq)
+		}
+	}
+	now := time.Now()
+	return n.store.Put(key, storage.Record{
+		Value:     rec.encode(),
+		StoredAt:  now,
+		ExpiresAt: now.Add(n.clampTTL(ttl)),
+		Publisher: publisher,
+	})
+}
+
+// loadMutableLocal returns the record held for target, if any. A held
+// record that does not verify or belongs to another target is ignored, so
+// it can neither be served nor block newer records.
+func (n *Node) loadMutableLocal(target util.ID) (MutableRecord, bool) {
+	b, ok := n.loadLocal(MUTABLE_PREFIX + target.String())
+	if !ok {
+		return MutableRecord{}, false
+	}
+	rec, err := decodeMutable(b)
+	if err == nil {
+		err = rec.Verify()
+	}
+	if err == nil && rec.Target(n.ID.Len()) != target {
+		err = fmt.Errorf("record is for %s", rec.Target(n.ID.Len()))
+	}
+	if err != nil {
+		fmt.Printf("Stored mutable record %s corrupt: %v\n", target, err)
+		return MutableRecord{}, false
+	}
+	return rec, true
+}
+
+// PUT_MUTABLE <fromID> <pubHex> <seq> <valueHex> <sigHex> [ttlSeconds]
+func (n *Node) HandlePutMutable(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+	if len(msg.Args) < 5 {
+		return nil, fmt.Errorf("PUT_MUTABLE missing args: want <fromID> <pubHex> <seq> <valueHex> <sigHex> [ttlSeconds]")
+	}
+	if _, err := util.ParseHexID(msg.Args[0]); err != nil {
+		return nil, fmt.Errorf("PUT_MUTABLE bad fromID: %w", err)
+	}
+	rec, err := parseMutableArgs(msg.Args[1:5])
+	if err != nil {
+		return nil, fmt.Errorf("PUT_MUTABLE %w", err)
+	}
+
+	var ttl time.Duration
+	if len(msg.Args) > 5 {
+		secs, err := strconv.ParseInt(msg.Args[5], 10, 64)
+		if err != nil || secs < 0 {
+			return nil, fmt.Errorf("PUT_MUTABLE bad ttl %q", msg.Args[5])
+		}
+		ttl = time.Duration(secs) * time.Second
+	}
+
+	err = n.storeMutableLocal(rec, ttl, msg.Args[0])
+	return n.storeReply(msg, rec.Target(n.ID.Len()).String(), err)
+}
+
+// GET_MUTABLE <fromID> <targetHex>
+func (n *Node) HandleGetMutable(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+	if len(msg.Args) < 2 {
+		return nil, fmt.Errorf("GET_MUTABLE missing args: want <fromID> <targetHex>")
+	}
+	target, err := util.ParseHexID(msg.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("GET_MUTABLE bad target: %w", err)
+	}
+
+	rec, ok := n.loadMutableLocal(target)
+	if !ok {
+		return &kadnet.Message{
+			Type:  kadnet.MSG_NOT_FOUND,
+			RPCID: msg.RPCID,
+			Args:  []string{n.ID.String(), target.String()},
+		}, nil
+	}
+	return &kadnet.Message{
+		Type:  kadnet.MSG_MUTABLE_VALUE,
+		RPCID: msg.RPCID,
+		Args:  append([]string{n.ID.String()}, mutableArgs(rec)...),
+	}, nil
+}
+
+// MutableResult is the outcome of a mutable record lookup
+type MutableResult struct {
+	Record MutableRecord
+	// From is the contact that served Record, nil if it was held locally
+	From *kademlia.Contact
+	// Rejected lists responders whose record failed verification
+	Rejected []kademlia.Contact
+}
+
+// GetMutable asks the k closest nodes to pub's target for its record and
+// returns the verified one with the highest seq.
+func (n *Node) GetMutable(ctx context.Context, pub ed25519.PublicKey, perNodeTimeout time.Duration) (MutableResult, error) {
+	ctx, cancel := n.opContext(ctx)
+	defer cancel()
+
+	target := MutableTarget(pub, n.ID.Len())
+	var result MutableResult
+	found := false
+	if rec, ok := n.loadMutableLocal(target); ok {
+		result.Record, found = rec, true
+	}
+
+	closest := n.IterativeFindNode(ctx, target, perNodeTimeout)
+
+	// Unlike immutable values every replica may hold a different seq, so
+	// all of the k closest are asked rather than stopping at the first hit
+	type res struct {
+		rec   MutableRecord
+		from  kademlia.Contact
+		found bool
+		err   error
+	}
+	answered := len(closest) == 0 // nobody to ask is not a failure
+	resCh := make(chan res, len(closest))
+	var wg sync.WaitGroup
+	for _, c := range closest {
+		wg.Go(func() {
+			rec, ok, err := n.SendGetMutableSync(ctx, c, pub, perNodeTimeout)
+			resCh <- res{rec, c, ok, err}
+		})
+	}
+	go func() { wg.Wait(); close(resCh) }()
+
+	for r := range resCh {
+		if r.err == nil || errors.Is(r.err, ErrBadSignature) {
+			answered = true
+		}
+		if errors.Is(r.err, ErrBadSignature) {
+			fmt.Printf("Rejected mutable record for %s: %v\n", target, r.err)
+			result.Rejected = append(result.Rejected, r.from)
+			continue
+		}
+		if !r.found || (found && r.rec.Seq <= result.Record.Seq) {
+			continue
+		}
+		result.Record, result.From, found = r.rec, &r.from, true
+	}
+
+	if !found && ctx.Err() != nil {
+		return result, fmt.Errorf("no mutable record for %s: %w", target, ctx.Err())
+	}
+	if !found && !answered {
+		return result, fmt.Errorf("no mutable record for %s: none of %d node(s) answered", target, len(closest))
+	}
+	if !found {
+		return result, fmt.Errorf("%w for %s", ErrNoMutableRecord, target)
+	}
+	return result, nil
+}
+
+// PutMutable signs value with the next seq after the newest record found on
+// the network and stores it on the k closest nodes, then locally once the
+// write quorum acknowledged it.
+func (n *Node) PutMutable(ctx context.Context, priv ed25519.PrivateKey, value []byte) (MutableRecord, error) {
+	if len(value) > MUTABLE_MAX_VALUE {
+		return MutableRecord{}, fmt.Errorf("mutable value is %d bytes, max %d", len(value), MUTABLE_MAX_VALUE)
+	}
+	timeout := ADAPTIVE_TIMEOUT
+	ctx, cancel := n.opContext(ctx)
+	defer cancel()
+
+	var seq uint64 = 1
+	if cur, err := n.GetMutable(ctx, priv.Public().(ed25519.PublicKey), timeout); err == nil {
+		seq = cur.Record.Seq + 1
+	} else if !errors.Is(err, ErrNoMutableRecord) {
+		// The newest seq is unknown; publishing now could go backwards
+		return MutableRecord{}, fmt.Errorf("find current record: %w", err)
+	}
+	rec := SignMutable(priv, seq, value)
+
+	ttl := n.Config.DefaultTTL
+	if _, err := n.publishMutable(ctx, rec, ttl, timeout, n.Config.WriteQuorum); err != nil {
+		return MutableRecord{}, err
+	}
+	if err := n.storeMutableLocal(rec, ttl, ""); err != nil {
+		return MutableRecord{}, fmt.Errorf("store locally: %w", err)
+	}
+	key := MUTABLE_PREFIX + rec.Target(n.ID.Len()).String()
+	n.trackPublication(key, rec.encode(), time.Now())
+	return rec, nil
+}
+
+// publishMutable sends rec to the k closest contacts to its target and
+// returns the ones that acknowledged, failing if fewer than quorum did.
+func (n *Node) publishMutable(ctx context.Context, rec MutableRecord, ttl time.Duration, timeout time.Duration, quorum int) ([]kademlia.Contact, error) {
+	return n.replicate(ctx, rec.Target(n.ID.Len()), quorum, timeout, func(ctx context.Context, c kademlia.Contact) error {
+		return n.SendPutMutableSync(ctx, c, rec, ttl, timeout)
+	})
+}
+
+// publishKey republishes a store entry, using PUT_MUTABLE for mutable records
+func (n *Node) publishKey(ctx context.Context, key string, value []byte, ttl time.Duration, timeout time.Duration) error {
+	if !strings.HasPrefix(key, MUTABLE_PREFIX) {
+		keyHex, manifest := strings.CutPrefix(key, MANIFEST_PREFIX)
+		_, err := n.publish(ctx, keyHex, value, manifest, ttl, timeout, 0)
+		return err
+	}
+	rec, err := decodeMutable(value)
+	if err != nil {
+		return err
+	}
+	_, err = n.publishMutable(ctx, rec, ttl, timeout, 0)
+	return err
+}
+
+// storeKeyLocal stores one of this node's own entries, which may be a
+// mutable record
+func (n *Node) storeKeyLocal(key string, value []byte, ttl time.Duration) error {
+	if !strings.HasPrefix(key, MUTABLE_PREFIX) {
+		return n.storeLocal(key, value, ttl, "")
+	}
+	rec, err := decodeMutable(value)
+	if err != nil {
+		return err
+	}
+	return n.storeMutableLocal(rec, ttl, "")
+}
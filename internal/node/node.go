@@ -2,15 +2,17 @@ package node
 
 import (
 	"context"
-	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
 	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/storage"
 	"github.com/t0sic/D7024E-Kademlia/internal/util"
 )
 
@@ -20,17 +22,98 @@ type NodeConfig struct {
 	Bootstrap bool
 	Peers     []string
 	NewNet    func(addr string) kadnet.Network
+	// DefaultTTL is used for values stored without a TTL, MaxTTL caps any
+	// requested TTL and SweepInterval controls how often expired values are
+	// deleted. Zero values fall back to DEFAULT_TTL, MAX_TTL and SWEEP_INTERVAL.
+	DefaultTTL    time.Duration
+	MaxTTL        time.Duration
+	SweepInterval time.Duration
+	// RepublishInterval is how often keys this node originated are stored
+	// again, and at least twice per DefaultTTL; ReplicateInterval how often
+	// held keys are pushed to the current k closest. Zero values fall back
+	// to REPUBLISH_INTERVAL and REPLICATE_INTERVAL.
+	RepublishInterval time.Duration
+	ReplicateInterval time.Duration
+	// DataDir selects the file-backed store and enables routing-table
+	// snapshots every SnapshotInterval; empty keeps everything in memory.
+	DataDir          string
+	SnapshotInterval time.Duration
+	// DisablePathCache turns off caching found values at the closest lookup
+	// node that did not have them.
+	DisablePathCache bool
+	// WireFormat is kadnet.FORMAT_BINARY (default, negotiated per peer) or
+	// kadnet.FORMAT_TEXT to never send binary packets.
+	WireFormat string
+	// MaxValueSize, MaxStoreBytes and MaxPublisherBytes bound what peers may
+	// store here; EvictionPolicy (EVICT_FURTHEST or EVICT_LRU) picks victims
+	// once MaxStoreBytes is reached. Zero values fall back to the defaults.
+	MaxValueSize      int
+	MaxStoreBytes     int64
+	MaxPublisherBytes int64
+	EvictionPolicy    string
+	// WriteQuorum is how many remote nodes must acknowledge a Put for it to
+	// succeed, at most as many as the routing table holds. Zero falls back to
+	// WRITE_QUORUM and NO_WRITE_QUORUM requires none.
+	WriteQuorum int
+	// RefreshInterval is how long a bucket may go untouched before it is
+	// refreshed with a random-ID lookup. Zero falls back to REFRESH_INTERVAL.
+	RefreshInterval time.Duration
+	// MinRTO and MaxRTO bound the per-peer RPC timeout estimated from
+	// measured round trips, used wherever a zero timeout is passed. Zero
+	// values fall back to MIN_RTO and MAX_RTO.
+	MinRTO time.Duration
+	MaxRTO time.Duration
+	// K, Alpha and Replication are the bucket size, lookup concurrency and
+	// replication factor. Together with the width of ID they must match on
+	// every node of a network; nodes refuse messages from nodes that differ.
+	// Zero values fall back to kademlia.K, kademlia.ALPHA and kademlia.K.
+	K           int
+	Alpha       int
+	Replication int
+	// TableLayout is kademlia.TABLE_FLAT (default) or kademlia.TABLE_TREE;
+	// RelaxedSplit lets a tree table split buckets away from our own ID.
+	TableLayout  string
+	RelaxedSplit bool
+	// OperationTimeout bounds lookups, Puts and Gets whose context has no
+	// deadline. Zero falls back to OPERATION_TIMEOUT.
+	OperationTimeout time.Duration
+	// JoinRetryMin and JoinRetryMax bound the backoff between attempts to
+	// join through Peers. Zero values fall back to JOIN_RETRY_MIN and
+	// JOIN_RETRY_MAX.
+	JoinRetryMin time.Duration
+	JoinRetryMax time.Duration
 }
 
 type Node struct {
 	ID           util.ID
 	Addr         string
 	Server       kadnet.Network
-	RoutingTable *kademlia.RoutingTable
+	RoutingTable kademlia.Table
 	Config       NodeConfig
-	// simple local storage for PUT/STORE operations (in-memory)
-	store   map[string][]byte
-	storeMu sync.RWMutex
+	// local storage for PUT/STORE operations (in-memory or file-backed)
+	store storage.Store
+	// keys this node originated, republished until shutdown
+	published map[string]*publication
+	pubMu     sync.Mutex
+	// serialises the seq check and write of mutable records
+	mutableMu sync.Mutex
+	// full-bucket members waiting for a liveness ping, deduplicated by ID
+	evictQueue   chan kademlia.Contact
+	evictPending map[string]bool
+	evictMu      sync.Mutex
+	// RFC 6298 timeout estimate per peer address
+	rtts  map[string]*rttEstimate
+	rttMu sync.Mutex
+
+	quit     chan struct{}
+	quitOnce sync.Once
+	// lifetime is cancelled on shutdown, aborting operations in flight
+	lifetime context.Context
+	end      context.CancelFunc
+	// closed once joined; rejoin wakes the joiner when the table empties
+	ready     chan struct{}
+	readyOnce sync.Once
+	rejoin    chan struct{}
 }
 
 func CreateNode(config NodeConfig) *Node {
@@ -42,7 +125,91 @@ func CreateNode(config NodeConfig) *Node {
 
 	newNet := config.NewNet
 	if newNet == nil {
-		newNet = func(a string) kadnet.Network { return kadnet.CreateUDPServer(a) }
+		newNet = func(a string) kadnet.Network {
+			s := kadnet.CreateUDPServer(a)
+			if config.WireFormat != "" {
+				if err := s.SetWireFormat(config.WireFormat); err != nil {
+					panic(err)
+				}
+			}
+			return s
+		}
+	}
+	if config.DefaultTTL <= 0 {
+		config.DefaultTTL = DEFAULT_TTL
+	}
+	if config.MaxTTL <= 0 {
+		config.MaxTTL = MAX_TTL
+	}
+	if config.SweepInterval <= 0 {
+		config.SweepInterval = SWEEP_INTERVAL
+	}
+	if config.RepublishInterval <= 0 {
+		config.RepublishInterval = REPUBLISH_INTERVAL
+	}
+	if config.ReplicateInterval <= 0 {
+		config.ReplicateInterval = REPLICATE_INTERVAL
+	}
+	if config.SnapshotInterval <= 0 {
+		config.SnapshotInterval = SNAPSHOT_INTERVAL
+	}
+	if config.MaxValueSize <= 0 {
+		config.MaxValueSize = MAX_VALUE_SIZE
+	}
+	if config.MaxStoreBytes <= 0 {
+		config.MaxStoreBytes = MAX_STORE_BYTES
+	}
+	if config.MaxPublisherBytes <= 0 {
+		config.MaxPublisherBytes = MAX_PUBLISHER_BYTES
+	}
+	if config.EvictionPolicy == "" {
+		config.EvictionPolicy = EVICT_FURTHEST
+	}
+	if config.WriteQuorum == 0 {
+		config.WriteQuorum = WRITE_QUORUM
+	}
+	if config.WriteQuorum < 0 {
+		config.WriteQuorum = 0
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = REFRESH_INTERVAL
+	}
+	if config.MinRTO <= 0 {
+		config.MinRTO = MIN_RTO
+	}
+	if config.MaxRTO <= 0 {
+		config.MaxRTO = MAX_RTO
+	}
+	if config.MaxRTO < config.MinRTO {
+		config.MaxRTO = config.MinRTO
+	}
+	if config.K <= 0 {
+		config.K = kademlia.K
+	}
+	if config.Alpha <= 0 {
+		config.Alpha = kademlia.ALPHA
+	}
+	if config.Replication <= 0 {
+		config.Replication = kademlia.K
+	}
+	if config.TableLayout == "" {
+		config.TableLayout = kademlia.TABLE_FLAT
+	}
+	if config.OperationTimeout <= 0 {
+		config.OperationTimeout = OPERATION_TIMEOUT
+	}
+	if config.JoinRetryMin <= 0 {
+		config.JoinRetryMin = JOIN_RETRY_MIN
+	}
+	if config.JoinRetryMax <= 0 {
+		config.JoinRetryMax = JOIN_RETRY_MAX
+	}
+	if config.JoinRetryMax < config.JoinRetryMin {
+		config.JoinRetryMax = config.JoinRetryMin
+	}
+	params := kademlia.Params{K: config.K, Alpha: config.Alpha, Replication: config.Replication, IDBytes: config.ID.Len()}
+	if err := params.Validate(); err != nil {
+		panic(fmt.Errorf("invalid network parameters: %w", err))
 	}
 
 	udpAddr, err := net.ResolveUDPAddr("udp", config.Addr)
@@ -51,61 +218,64 @@ func CreateNode(config NodeConfig) *Node {
 	}
 	var contact kademlia.Contact = kademlia.NewContact(&config.ID, udpAddr)
 
+	table, err := kademlia.NewTable(config.TableLayout, contact, params, config.RelaxedSplit)
+	if err != nil {
+		panic(err)
+	}
+
+	store, err := openStore(config)
+	if err != nil {
+		panic(fmt.Errorf("open store %q: %w", config.DataDir, err))
+	}
+
 	node := &Node{
 		ID:           config.ID,
 		Addr:         config.Addr,
 		Server:       newNet(config.Addr),
-		RoutingTable: kademlia.CreateRoutingTable(contact),
+		RoutingTable: table,
 		Config:       config,
+		store:        store,
+		published:    make(map[string]*publication),
+		evictQueue:   make(chan kademlia.Contact, EVICTION_QUEUE_SIZE),
+		evictPending: make(map[string]bool),
+		rtts:         make(map[string]*rttEstimate),
+		quit:         make(chan struct{}),
+		ready:        make(chan struct{}),
+		rejoin:       make(chan struct{}, 1),
 	}
+	node.lifetime, node.end = context.WithCancel(context.Background())
 
-	node.Server.On(kadnet.MSG_PING, node.HandlePing)
-	node.Server.On(kadnet.MSG_PONG, node.HandlePong)
-	node.Server.On(kadnet.MSG_FIND_NODE, node.HandleFindNode)
-	node.Server.On(kadnet.MSG_STORE, node.HandleStore)
-	node.Server.On(kadnet.MSG_GET, node.HandleGet)
+	node.Server.On(kadnet.MSG_PING, node.guard(node.HandlePing))
+	node.Server.On(kadnet.MSG_PONG, node.guard(node.HandlePong))
+	node.Server.On(kadnet.MSG_FIND_NODE, node.guard(node.HandleFindNode))
+	node.Server.On(kadnet.MSG_FIND_VALUE, node.guard(node.HandleFindValue))
+	node.Server.On(kadnet.MSG_STORE, node.guard(node.HandleStore))
+	node.Server.On(kadnet.MSG_GET, node.guard(node.HandleGet))
+	node.Server.On(kadnet.MSG_PUT_MUTABLE, node.guard(node.HandlePutMutable))
+	node.Server.On(kadnet.MSG_GET_MUTABLE, node.guard(node.HandleGetMutable))
 
 	go func() {
 		if err := node.Server.Start(); err != nil {
 			fmt.Println("UDP server stopped:", err)
 		}
 	}()
-	node.JoinNetwork()
-
-	return node
-}
-
-func (n *Node) JoinNetwork() {
-	fmt.Println("Joining network...")
-	if len(n.Config.Peers) == 0 {
-		return
+	for i := 0; i < EVICTION_WORKERS; i++ {
+		go node.runEvictor()
 	}
-
-	for _, peer := range n.Config.Peers {
-		addr, err := net.ResolveUDPAddr("udp", peer)
-		if err != nil {
-			fmt.Printf("Resolve %s: %v\n", peer, err)
-			continue
-		}
-
-		peerID, err := n.PingSync(addr, 800*time.Millisecond)
-		if err != nil {
-			fmt.Printf("PING -> %s failed: %v\n", peer, err)
-			continue
-		}
-		fmt.Printf("Peer %s is alive (id=%s)\n", peer, peerID.String())
-
-		// Add bootstrap peer explicitly to routing table
-		n.AddContact(kademlia.NewContactWithDistance(&n.ID, addr, &peerID))
-
-		// print result save it in a variable if needed
-		contacts := n.IterativeFindNode(n.ID, 800*time.Millisecond)
-
-		// print contacts
-		for _, c := range contacts {
-			fmt.Printf("Found contact: %s\n", c.String())
-		}
+	go node.runSweeper()
+	go node.runRepublisher()
+	go node.runRefresher()
+	if config.DataDir != "" {
+		go node.runSnapshotter()
+	}
+	if len(config.Peers) > 0 || node.hasSnapshot() {
+		go node.runJoiner()
+	} else {
+		// Nothing to join through; others join through us
+		node.markReady()
 	}
+
+	return node
 }
 
 // FIND_NODE <fromID> <targetID>
@@ -126,9 +296,14 @@ func (n *Node) HandleFindNode(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Me
 	}
 
 	n.AddContact(kademlia.NewContactWithDistance(&n.ID, from, &fromID))
-	shortlist := n.RoutingTable.FindClosestContacts(&targetID, kademlia.K)
+	return n.nodesReply(msg, fromID, targetID), nil
+}
+
+// nodesReply builds NODES <myID> <id@host:port>... with the k closest
+// contacts to target, leaving out the requester and this node.
+func (n *Node) nodesReply(msg kadnet.Message, fromID, target util.ID) *kadnet.Message {
+	shortlist := n.RoutingTable.FindClosestContacts(&target, n.Config.Replication)
 
-	// Build: NODES <myID> <id@host:port>...
 	args := []string{n.ID.String()}
 	for _, c := range shortlist {
 		// skip the requester and self
@@ -142,7 +317,45 @@ func (n *Node) HandleFindNode(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Me
 		Type:  kadnet.MSG_NODES,
 		RPCID: msg.RPCID,
 		Args:  args,
-	}, nil
+	}
+}
+
+// FIND_VALUE <fromID> <keyHex>
+// Answers VALUE <myID> <keyHex> <valueHex> [manifest] if the value is held
+// here and NODES like FIND_NODE otherwise.
+func (n *Node) HandleFindValue(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+	if len(msg.Args) < 2 {
+		return nil, fmt.Errorf("FIND_VALUE missing args: want <fromID> <keyHex>")
+	}
+	fromID, err := util.ParseHexID(msg.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid node ID in FIND_VALUE message: %w", err)
+	}
+	keyID, err := util.ParseHexID(msg.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in FIND_VALUE message: %w", err)
+	}
+
+	n.AddContact(kademlia.NewContactWithDistance(&n.ID, from, &fromID))
+
+	if val, manifest, ok := n.loadValue(keyID.String()); ok {
+		return n.valueReply(msg, keyID.String(), val, manifest), nil
+	}
+	return n.nodesReply(msg, fromID, keyID), nil
+}
+
+// valueReply answers with VALUE <myID> <keyHex> <valueHex>, flagged if the
+// value is a manifest
+func (n *Node) valueReply(msg kadnet.Message, keyHex string, val []byte, manifest bool) *kadnet.Message {
+	args := []string{n.ID.String(), keyHex, hex.EncodeToString(val)}
+	if manifest {
+		args = append(args, MANIFEST_FLAG)
+	}
+	return &kadnet.Message{
+		Type:  kadnet.MSG_VALUE,
+		RPCID: msg.RPCID,
+		Args:  args,
+	}
 }
 
 func (n *Node) HandlePong(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
@@ -178,20 +391,26 @@ func (n *Node) HandlePing(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Messag
 
 }
 
-// handle store
+// STORE <fromID> <keyHex> <valueHex> [ttlSeconds [manifest]]
 func (n *Node) HandleStore(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
 	if len(msg.Args) < 3 {
-		return nil, fmt.Errorf("STORE missing args: want <fromID> <keyHex> <valueHex>")
+		return nil, fmt.Errorf("STORE missing args: want <fromID> <keyHex> <valueHex> [ttlSeconds [manifest]]")
 	}
 
-	fromID, err := util.ParseHexID(msg.Args[0])
-	if err != nil {
+	if _, err := util.ParseHexID(msg.Args[0]); err != nil {
 		return nil, fmt.Errorf("STORE bad fromID: %w", err)
 	}
 
-	_ = fromID
-
-	keyHex := msg.Args[1]
+	// Only plain IDs of our width; prefixed keys would bypass the checks
+	// mutable records and manifests get
+	keyID, err := util.ParseHexID(msg.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("STORE bad key: %w", err)
+	}
+	if keyID.Len() != n.ID.Len() {
+		return nil, fmt.Errorf("STORE key is %d bytes, want %d", keyID.Len(), n.ID.Len())
+	}
+	keyHex := keyID.String()
 	valHex := msg.Args[2]
 
 	value, err := hex.DecodeString(valHex)
@@ -199,21 +418,28 @@ func (n *Node) HandleStore(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Messa
 		return nil, fmt.Errorf("STORE value not hex: %w", err)
 	}
 
-	n.storeMu.Lock()
-	if n.store == nil {
-		n.store = make(map[string][]byte)
+	// Optional requested TTL, clamped to the node's maximum by storeLocal
+	var ttl time.Duration
+	if len(msg.Args) > 3 {
+		secs, err := strconv.ParseInt(msg.Args[3], 10, 64)
+		if err != nil || secs < 0 {
+			return nil, fmt.Errorf("STORE bad ttl %q", msg.Args[3])
+		}
+		ttl = time.Duration(secs) * time.Second
 	}
-	buf := make([]byte, len(value))
-	copy(buf, value)
-	n.store[keyHex] = buf
-	n.storeMu.Unlock()
 
-	// Ack
-	return &kadnet.Message{
-		Type:  kadnet.MSG_STORED,
-		RPCID: msg.RPCID,
-		Args:  []string{n.ID.String(), keyHex},
-	}, nil
+	// Manifests are kept apart so they are served with their flag
+	key := keyHex
+	if len(msg.Args) > 4 {
+		if msg.Args[4] != MANIFEST_FLAG {
+			return nil, fmt.Errorf("STORE unknown flag %q", msg.Args[4])
+		}
+		key = MANIFEST_PREFIX + keyHex
+	}
+
+	// Ack, or tell the sender why its value was not kept
+	err = n.storeLocal(key, value, ttl, msg.Args[0])
+	return n.storeReply(msg, keyHex, err)
 }
 
 func (n *Node) HandleGet(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
@@ -221,12 +447,18 @@ func (n *Node) HandleGet(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message
 	if len(msg.Args) < 2 {
 		return nil, fmt.Errorf("GET missing args: want <fromID> <keyHex>")
 	}
-	keyHex := msg.Args[1]
-
-	n.storeMu.RLock()
-	val, ok := n.store[keyHex]
-	n.storeMu.RUnlock()
+	// Like STORE, only plain IDs; prefixed keys would serve mutable records
+	// and manifests as plain values
+	keyID, err := util.ParseHexID(msg.Args[1])
+	if err != nil {
+		return nil, fmt.Errorf("GET bad key: %w", err)
+	}
+	if keyID.Len() != n.ID.Len() {
+		return nil, fmt.Errorf("GET key is %d bytes, want %d", keyID.Len(), n.ID.Len())
+	}
+	keyHex := keyID.String()
 
+	val, manifest, ok := n.loadValue(keyHex)
 	if !ok {
 		return &kadnet.Message{
 			Type:  kadnet.MSG_NOT_FOUND,
@@ -234,205 +466,186 @@ func (n *Node) HandleGet(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message
 			Args:  []string{n.ID.String(), keyHex},
 		}, nil
 	}
-
-	return &kadnet.Message{
-		Type:  kadnet.MSG_VALUE,
-		RPCID: msg.RPCID,
-		Args:  []string{n.ID.String(), keyHex, hex.EncodeToString(val)},
-	}, nil
+	return n.valueReply(msg, keyHex, val, manifest), nil
 }
 
+// AddContact adds c to the routing table. If c's bucket is full, c waits in
+// the replacement cache while the least recently seen member is pinged in
+// the background, so handlers calling this never block on the network.
 func (n *Node) AddContact(c kademlia.Contact) {
-	evictCandidate := n.RoutingTable.AddContact(c)
-	if evictCandidate != nil {
-		_, err := n.PingSync(&evictCandidate.Address, 800*time.Millisecond)
-		if err != nil {
-			n.RoutingTable.RemoveContact(*evictCandidate)
-			n.RoutingTable.AddContact(c)
-			fmt.Printf("PING -> %s failed: %v\n", evictCandidate.Address.String(), err)
-		}
+	if evictCandidate := n.RoutingTable.AddContact(c); evictCandidate != nil {
+		n.queueEvictionCheck(*evictCandidate)
 	}
 }
 
-// Put stores the provided data locally and returns the SHA-1 hash bytes for the stored value.
-func (n *Node) Put(data []byte) ([]byte, error) {
+// PutResult reports where a Put was stored
+type PutResult struct {
+	// Key is the util.HashID of the value, or of the manifest for chunked values
+	Key []byte
+	// Replicas are the remote contacts that acknowledged storing Key
+	Replicas []kademlia.Contact
+}
+
+// Put stores the provided data and returns its util.HashID together with the
+// contacts that acknowledged it. It fails if fewer than WriteQuorum did.
+// Data larger than CHUNK_SIZE is split into chunks and the returned hash is
+// that of the manifest listing them; Get reassembles it. The Put is
+// abandoned once ctx is done.
+func (n *Node) Put(ctx context.Context, data []byte) (PutResult, error) {
 	fmt.Println("Recieved PUT with data length:", len(data))
 	if len(data) == 0 {
-		return nil, fmt.Errorf("cannot store empty data")
+		return PutResult{}, fmt.Errorf("cannot store empty data")
 	}
+	ctx, cancel := n.opContext(ctx)
+	defer cancel()
+	if len(data) > CHUNK_SIZE {
+		return n.putChunked(ctx, data, 0)
+	}
+	return n.putValue(ctx, data, false)
+}
 
-	// 1) key = sha1(data) as hex
-	h := sha1.Sum(data)
-	keyHex := hex.EncodeToString(h[:])
-
-	// 2) lookup k-closest to key
-	keyID, err := util.ParseHexID(keyHex)
+// putValue stores a value that fits in one datagram under its hash,
+// flagged as a manifest if manifest is set.
+func (n *Node) putValue(ctx context.Context, data []byte, manifest bool) (PutResult, error) {
+	// 1) key = hash(data) as hex, SHA-1 or SHA-256 by ID width
+	key := util.HashID(data, n.ID.Len())
+	keyHex := key.String()
+
+	// 2) lookup k-closest to key and 3) send STORE to each
+	timeout := ADAPTIVE_TIMEOUT
+	ttl := n.Config.DefaultTTL
+	replicas, err := n.publish(ctx, keyHex, data, manifest, ttl, timeout, n.Config.WriteQuorum)
 	if err != nil {
-		return nil, fmt.Errorf("parse key: %w", err)
+		return PutResult{Key: key.Bytes(), Replicas: replicas}, fmt.Errorf("put %s: %w", keyHex, err)
 	}
-	timeout := 800 * time.Millisecond
-	closest := n.IterativeFindNode(keyID, timeout)
 
-	// 3) send STORE to each
-	for _, c := range closest {
-		_ = n.SendStoreSync(c, keyHex, data, timeout)
+	// 4) also store locally, and remember we are the original publisher
+	storeKey := keyHex
+	if manifest {
+		storeKey = MANIFEST_PREFIX + keyHex
 	}
-
-	// 4) also store locally
-	n.storeMu.Lock()
-	if n.store == nil {
-		n.store = make(map[string][]byte)
+	if err := n.storeLocal(storeKey, data, ttl, ""); err != nil {
+		return PutResult{}, fmt.Errorf("store locally: %w", err)
 	}
-	b := make([]byte, len(data))
-	copy(b, data)
-	n.store[keyHex] = b
-	n.storeMu.Unlock()
+	n.trackPublication(storeKey, data, time.Now())
 
 	// 5) return same as before so CLI prints hex
-	return h[:], nil
+	return PutResult{Key: key.Bytes(), Replicas: replicas}, nil
 }
 
-// IterativeFindNode runs the Kademlia iterative FIND_NODE lookup.
-// Returns up to kademlia.K closest contacts to the target.
-func (n *Node) IterativeFindNode(target util.ID, timeout time.Duration) []kademlia.Contact {
-	// Start shortlist from routing table
-	shortlist := n.RoutingTable.FindClosestContacts(&target, kademlia.K)
-
-	// Track queried nodes
-	queried := make(map[string]bool)
-
-	progress := true
-	for progress {
-		progress = false
-
-		// Select up to ALPHA closest unqueried contacts
-		batch := make([]kademlia.Contact, 0, kademlia.ALPHA)
-		for _, c := range shortlist {
-			if len(batch) >= kademlia.ALPHA {
-				break
-			}
-			if !queried[c.ID.String()] {
-				batch = append(batch, c)
-				queried[c.ID.String()] = true
-			}
-		}
-
-		// If no new nodes to query, we’re done
-		if len(batch) == 0 {
-			break
-		}
-
-		// Query them in parallel
-		type result struct {
-			from     kademlia.Contact
-			contacts []kademlia.Contact
-			err      error
-		}
-		results := make(chan result, len(batch))
-		for _, c := range batch {
-			go func(c kademlia.Contact) {
-				contacts, err := n.FindNodesSync(&c.Address, n.ID, target, timeout)
-				if err != nil {
-					results <- result{from: c, contacts: nil, err: err}
-					return
-				}
-				results <- result{from: c, contacts: contacts, err: nil}
-			}(c)
-		}
+// LookupResult is the outcome of a node lookup
+type LookupResult struct {
+	// Contacts are up to Config.Replication contacts closest to the target
+	Contacts []kademlia.Contact
+	// Hops is how many replies separate our routing table from the closest
+	// contact: 0 if we already knew it, 1 if a contact we knew returned it
+	Hops int
+	// Queried counts the FIND_NODE RPCs sent
+	Queried int
+}
 
-		// Collect results
-		for i := 0; i < len(batch); i++ {
-			res := <-results
-			if res.err != nil {
-				continue
-			}
-			for _, c := range res.contacts {
-				c.CalcDistance(&target)
-				n.AddContact(c) // maintain table
-				// Add if not seen
-				if !contains(shortlist, c) {
-					shortlist = append(shortlist, c)
-					progress = true
-				}
-			}
-		}
+// IterativeFindNode runs the Kademlia iterative FIND_NODE lookup.
+// Returns up to Config.Replication closest contacts to the target.
+// A ctx from WithTrace records the lookup query by query.
+func (n *Node) IterativeFindNode(ctx context.Context, target util.ID, timeout time.Duration) []kademlia.Contact {
+	return n.FindNode(ctx, target, timeout).Contacts
+}
 
-		// Sort shortlist by distance to target
-		cand := kademlia.ContactCandidates{}
-		cand.Append(shortlist)
-		cand.Sort()
-		shortlist = cand.GetContacts(kademlia.K)
-	}
+// FindNode runs the iterative FIND_NODE lookup and reports how far it went.
+// If ctx is done first, it returns the closest contacts found so far.
+func (n *Node) FindNode(ctx context.Context, target util.ID, timeout time.Duration) LookupResult {
+	ctx, cancel := n.opContext(ctx)
+	defer cancel()
+
+	l := n.newLookup(kadnet.MSG_FIND_NODE, target)
+	l.run(ctx, func(ctx context.Context, c kademlia.Contact) lookupReply {
+		contacts, err := n.FindNodesSync(ctx, &c.Address, n.ID, target, timeout)
+		return lookupReply{contacts: contacts, err: err}
+	}, nil)
+	return l.result()
+}
 
-	return shortlist
+// ValueResult is the outcome of a value lookup
+type ValueResult struct {
+	Value []byte
+	// Manifest is set if Value is a chunk manifest rather than content
+	Manifest bool
+	// From is the contact that served Value, nil if it was found locally
+	From *kademlia.Contact
+	// Rejected lists responders whose value did not hash to the key
+	Rejected []kademlia.Contact
 }
 
 // IterativeFindValue returnerar (value, fromContact, error).
 // fromContact == nil betyder att värdet hittades lokalt.
-func (n *Node) IterativeFindValue(keyID util.ID, perNodeTimeout time.Duration) ([]byte, *kademlia.Contact, error) {
-	keyHex := keyID.String()
+func (n *Node) IterativeFindValue(ctx context.Context, keyID util.ID, perNodeTimeout time.Duration) ([]byte, *kademlia.Contact, error) {
+	res, err := n.FindValue(ctx, keyID, perNodeTimeout)
+	return res.Value, res.From, err
+}
 
-	// 1) Hämta k-närmsta via din befintliga iterative FIND_NODE
-	closest := n.IterativeFindNode(keyID, perNodeTimeout)
-	if len(closest) == 0 {
-		return nil, nil, fmt.Errorf("no closest contacts for %s", keyHex)
+// FindValue returns a value held in the local store, and otherwise runs the
+// iterative FIND_VALUE lookup, stopping at the first reply that carries the
+// value. Responders that served content not matching the key are reported
+// and skipped while the lookup continues.
+// A ctx from WithTrace records the lookup query by query.
+func (n *Node) FindValue(ctx context.Context, keyID util.ID, perNodeTimeout time.Duration) (ValueResult, error) {
+	keyHex := keyID.String()
+	var result ValueResult
+	if val, manifest, ok := n.loadValue(keyHex); ok && util.HashID(val, keyID.Len()) == keyID {
+		result.Value, result.Manifest = val, manifest
+		return result, nil
 	}
+	ctx, cancel := n.opContext(ctx)
+	defer cancel()
 
-	// 2) Fråga i ALPHA-vågor parallellt. Bryt på första VALUE.
-	for i := 0; i < len(closest); i += kademlia.ALPHA {
-		end := i + kademlia.ALPHA
-		if end > len(closest) {
-			end = len(closest)
-		}
-		batch := closest[i:end]
-
-		type res struct {
-			val  []byte
-			from kademlia.Contact
-			ok   bool
-			err  error
-		}
-		resCh := make(chan res, len(batch))
-		var wg sync.WaitGroup
-
-		for _, c := range batch {
-			wg.Go(func() {
-				val, found, err := n.SendGetSync(c, keyHex, perNodeTimeout)
-				if err != nil {
-					resCh <- res{nil, c, false, err}
-					return
-				}
-				if found {
-					resCh <- res{val, c, true, nil}
-					return
-				}
-				resCh <- res{nil, c, false, nil}
-			})
-		}
-
-		go func() { wg.Wait(); close(resCh) }()
-
-		for r := range resCh {
-			if r.ok && r.err == nil {
-				return r.val, &r.from, nil
-			}
-		}
+	l := n.newLookup(kadnet.MSG_FIND_VALUE, keyID)
+	if len(l.shortlist) == 0 {
+		return result, fmt.Errorf("no closest contacts for %s", keyHex)
 	}
 
-	return nil, nil, fmt.Errorf("value %s not found", keyHex)
-}
-
-// contains checks if a Contact with same ID exists in slice
-func contains(list []kademlia.Contact, c kademlia.Contact) bool {
-	for _, x := range list {
-		if x.ID.Equals(c.ID) {
+	// Nodes that answered with contacts are candidates for caching the value
+	var missed []kademlia.Contact
+	found := false
+
+	l.run(ctx, func(ctx context.Context, c kademlia.Contact) lookupReply {
+		val, ok, contacts, err := n.SendFindValueSync(ctx, c, keyID, perNodeTimeout)
+		return lookupReply{contacts: contacts, value: val.Data, manifest: val.Manifest, found: ok, err: err}
+	}, func(r lookupReply) bool {
+		switch {
+		case errors.Is(r.err, ErrHashMismatch):
+			fmt.Printf("Rejected value for %s: %v\n", keyHex, r.err)
+			result.Rejected = append(result.Rejected, r.from)
+		case r.err != nil:
+		case r.found:
+			n.cacheOnPath(keyID, r.value, r.manifest, missed, l.closest(), perNodeTimeout)
+			result.Value, result.Manifest, result.From = r.value, r.manifest, &r.from
+			found = true
 			return true
+		default:
+			missed = append(missed, r.from)
 		}
+		return false
+	})
+
+	if found {
+		return result, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return result, fmt.Errorf("value %s not found: %w", keyHex, err)
 	}
-	return false
+	if len(result.Rejected) > 0 {
+		return result, fmt.Errorf("value %s not found (%d responder(s) served mismatching content)", keyHex, len(result.Rejected))
+	}
+	return result, fmt.Errorf("value %s not found", keyHex)
 }
 
 func (n *Node) Shutdown(ctx context.Context) error {
+	n.quitOnce.Do(func() {
+		close(n.quit)
+		n.end()
+		n.saveSnapshot()
+	})
+
 	done := make(chan struct{})
 	go func() {
 		_ = n.Server.Close() // Close waits for the listener goroutine to finish
@@ -441,7 +654,7 @@ func (n *Node) Shutdown(ctx context.Context) error {
 
 	select {
 	case <-done:
-		return nil
+		return n.store.Close()
 	case <-ctx.Done():
 		return ctx.Err()
 	}
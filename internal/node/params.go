@@ -0,0 +1,65 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+)
+
+// PARAMS_ARG_PREFIX starts the trailing argument a node with non-default
+// parameters adds to every message it sends. Messages without it come from
+// nodes running kademlia.DefaultParams, so default nodes need no tag at all.
+const PARAMS_ARG_PREFIX = "net="
+
+// ErrParamsMismatch is returned for messages from nodes whose K, ALPHA,
+// replication factor or ID width differ from ours
+var ErrParamsMismatch = errors.New("network parameters mismatch")
+
+// tagParams returns msg with our parameters appended unless they are the defaults
+func (n *Node) tagParams(msg kadnet.Message) kadnet.Message {
+	params := n.RoutingTable.Params()
+	if params == kademlia.DefaultParams() {
+		return msg
+	}
+	msg.Args = append(append([]string(nil), msg.Args...), PARAMS_ARG_PREFIX+params.String())
+	return msg
+}
+
+// checkParams strips the parameters tag from msg and fails with
+// ErrParamsMismatch unless the sender runs our parameters
+func (n *Node) checkParams(msg kadnet.Message) (kadnet.Message, error) {
+	theirs := kademlia.DefaultParams()
+	if last := len(msg.Args) - 1; last >= 0 && strings.HasPrefix(msg.Args[last], PARAMS_ARG_PREFIX) {
+		p, err := kademlia.ParseParams(strings.TrimPrefix(msg.Args[last], PARAMS_ARG_PREFIX))
+		if err != nil {
+			return msg, fmt.Errorf("%w: %v", ErrParamsMismatch, err)
+		}
+		theirs = p
+		msg.Args = msg.Args[:last]
+	}
+	if ours := n.RoutingTable.Params(); theirs != ours {
+		return msg, fmt.Errorf("%w: %s sent by a node running %s, we run %s", ErrParamsMismatch, msg.Type, theirs, ours)
+	}
+	return msg, nil
+}
+
+// guard wraps h so messages from nodes with other parameters are refused
+// without a reply, and replies carry our parameters
+func (n *Node) guard(h kadnet.Handler) kadnet.Handler {
+	return func(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+		msg, err := n.checkParams(msg)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := h(from, msg)
+		if reply != nil {
+			tagged := n.tagParams(*reply)
+			reply = &tagged
+		}
+		return reply, err
+	}
+}
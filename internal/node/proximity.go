@@ -0,0 +1,59 @@
+package node
+
+import (
+	"sort"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// RTT_TIER is the resolution at which lookups compare RTTs; contacts whose
+// RTTs fall in the same tier are told apart by distance alone
+const RTT_TIER = 5 * time.Millisecond
+
+// nextBatch picks up to max unqueried contacts from the distance-sorted
+// shortlist and marks them queried. Contacts in the same distance class,
+// sharing as many leading bits with target, are ordered by measured RTT tier
+// so nearby nodes are asked first; contacts never measured go after measured
+// ones of their class.
+func (n *Node) nextBatch(shortlist []kademlia.Contact, queried map[string]bool, target util.ID, max int) []kademlia.Contact {
+	type candidate struct {
+		c     kademlia.Contact
+		class int
+		tier  int64 // -1 until an RTT is measured
+	}
+	var cands []candidate
+	for _, c := range shortlist {
+		if queried[c.ID.String()] {
+			continue
+		}
+		cand := candidate{c: c, class: c.ID.CommonPrefixLen(&target), tier: -1}
+		if rtt := n.SmoothedRTT(&c.Address); rtt > 0 {
+			cand.tier = int64(rtt / RTT_TIER)
+		}
+		cands = append(cands, cand)
+	}
+
+	// Stable so ties keep the shortlist's distance order
+	sort.SliceStable(cands, func(i, j int) bool {
+		a, b := cands[i], cands[j]
+		if a.class != b.class {
+			return a.class > b.class
+		}
+		if (a.tier < 0) != (b.tier < 0) {
+			return b.tier < 0
+		}
+		return a.tier < b.tier
+	})
+
+	var batch []kademlia.Contact
+	for _, cand := range cands {
+		if len(batch) >= max {
+			break
+		}
+		batch = append(batch, cand.c)
+		queried[cand.c.ID.String()] = true
+	}
+	return batch
+}
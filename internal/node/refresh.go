@@ -0,0 +1,43 @@
+package node
+
+import (
+	"fmt"
+	"time"
+)
+
+// REFRESH_INTERVAL is how long a bucket may go untouched before a lookup
+// for a random ID in its range refreshes it.
+const REFRESH_INTERVAL = time.Hour
+
+// runRefresher periodically refreshes stale buckets until the node shuts down.
+func (n *Node) runRefresher() {
+	ticker := time.NewTicker(n.Config.RefreshInterval / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if refreshed := n.refreshBuckets(time.Now()); refreshed > 0 {
+				fmt.Printf("Refreshed %d stale bucket(s)\n", refreshed)
+			}
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+// refreshBuckets runs a lookup for a random ID in every bucket untouched for
+// RefreshInterval and returns how many buckets it refreshed.
+func (n *Node) refreshBuckets(now time.Time) int {
+	stale := n.RoutingTable.StaleBuckets(now.Add(-n.Config.RefreshInterval))
+	for _, i := range stale {
+		select {
+		case <-n.quit:
+			return 0
+		default:
+		}
+		// The lookup touches the bucket, even if it finds nobody new
+		n.IterativeFindNode(n.lifetime, n.RoutingTable.RandomIDInBucket(i), ADAPTIVE_TIMEOUT)
+	}
+	return len(stale)
+}
@@ -0,0 +1,257 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/storage"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// REPUBLISH_INTERVAL is how often the original publisher re-stores its keys.
+const REPUBLISH_INTERVAL = 24 * time.Hour
+
+// REPLICATE_INTERVAL is how often a holder re-replicates the keys it stores.
+const REPLICATE_INTERVAL = time.Hour
+
+// WRITE_QUORUM is how many remote acknowledgements a Put needs by default.
+const WRITE_QUORUM = 1
+
+// NO_WRITE_QUORUM as NodeConfig.WriteQuorum lets a Put succeed without any
+// remote acknowledgement.
+const NO_WRITE_QUORUM = -1
+
+// publication is a key this node originated through Put
+type publication struct {
+	value         []byte
+	lastPublished time.Time
+}
+
+// trackPublication remembers that this node is the original publisher of keyHex.
+func (n *Node) trackPublication(keyHex string, value []byte, at time.Time) {
+	buf := make([]byte, len(value))
+	copy(buf, value)
+
+	n.pubMu.Lock()
+	n.published[keyHex] = &publication{value: buf, lastPublished: at}
+	n.pubMu.Unlock()
+}
+
+// pruneGone forgets originated keys the store no longer holds
+func (n *Node) pruneGone() {
+	n.pubMu.Lock()
+	tracked := make(map[string]*publication, len(n.published))
+	for key, p := range n.published {
+		tracked[key] = p
+	}
+	n.pubMu.Unlock()
+
+	// Looked up outside pubMu, which store iteration callbacks take
+	for key := range tracked {
+		if _, ok := n.loadLocal(key); ok {
+			delete(tracked, key)
+		}
+	}
+
+	n.pubMu.Lock()
+	for key, p := range tracked {
+		// A Put since the lookup tracked the key again
+		if n.published[key] == p {
+			delete(n.published, key)
+		}
+	}
+	n.pubMu.Unlock()
+}
+
+// publish stores value, flagged if it is a manifest, on the k closest
+// contacts to keyHex and returns the ones that acknowledged, failing if
+// fewer than quorum did.
+func (n *Node) publish(ctx context.Context, keyHex string, value []byte, manifest bool, ttl time.Duration, timeout time.Duration, quorum int) ([]kademlia.Contact, error) {
+	keyID, err := util.ParseHexID(keyHex)
+	if err != nil {
+		return nil, fmt.Errorf("parse key: %w", err)
+	}
+
+	return n.replicate(ctx, keyID, quorum, timeout, func(ctx context.Context, c kademlia.Contact) error {
+		return n.sendStore(ctx, c, keyHex, Value{Data: value, Manifest: manifest}, ttl, timeout)
+	})
+}
+
+// replicate runs send against the k closest contacts to key in parallel and,
+// while fewer than quorum acknowledged, against the next closest contacts in
+// the routing table. quorum is capped at the number of contacts we know, so
+// a network smaller than the quorum can still be written to while one whose
+// nodes stopped answering cannot. It returns the contacts whose send
+// succeeded, and stops sending once ctx is done.
+func (n *Node) replicate(ctx context.Context, key util.ID, quorum int, timeout time.Duration, send func(context.Context, kademlia.Contact) error) ([]kademlia.Contact, error) {
+	tried := make(map[string]bool)
+	var acked []kademlia.Contact
+	var mu sync.Mutex
+
+	sendAll := func(batch []kademlia.Contact) {
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			tried[c.ID.String()] = true
+			wg.Go(func() {
+				if err := send(ctx, c); err != nil {
+					if errors.Is(err, ErrStoreRefused) {
+						fmt.Println(err)
+					}
+					return
+				}
+				mu.Lock()
+				acked = append(acked, c)
+				mu.Unlock()
+			})
+		}
+		wg.Wait()
+	}
+
+	quorum = min(quorum, len(n.RoutingTable.FindClosestContacts(&key, quorum)))
+	sendAll(n.IterativeFindNode(ctx, key, timeout))
+
+	// Fall back to contacts beyond the k closest, a few at a time
+	if len(acked) < quorum {
+		var next []kademlia.Contact
+		for _, c := range n.RoutingTable.FindClosestContacts(&key, 2*n.Config.Replication) {
+			if !tried[c.ID.String()] && !c.ID.Equals(&n.ID) {
+				next = append(next, c)
+			}
+		}
+		for len(acked) < quorum && len(next) > 0 && ctx.Err() == nil {
+			batch := next[:min(quorum-len(acked), len(next))]
+			next = next[len(batch):]
+			sendAll(batch)
+		}
+	}
+
+	if len(acked) < quorum && ctx.Err() != nil {
+		return acked, fmt.Errorf("stored on %d node(s): %w", len(acked), ctx.Err())
+	}
+	if len(acked) < quorum {
+		return acked, fmt.Errorf("stored on %d node(s), write quorum is %d", len(acked), quorum)
+	}
+	return acked, nil
+}
+
+// republishEvery returns how often originated keys are republished: every
+// republish interval, but at least twice per TTL so they are refreshed
+// before they expire here or on the nodes holding them.
+func (n *Node) republishEvery() time.Duration {
+	return min(n.Config.RepublishInterval, n.Config.DefaultTTL/2)
+}
+
+// republishTick returns how often the republisher checks for due keys.
+func (n *Node) republishTick() time.Duration {
+	return min(n.Config.ReplicateInterval, n.republishEvery()) / 4
+}
+
+// runRepublisher periodically republishes and replicates keys until the node shuts down.
+func (n *Node) runRepublisher() {
+	ticker := time.NewTicker(n.republishTick())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.republishDue(time.Now())
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+// republishDue re-stores every originated key not published within
+// republishEvery and replicates every held key not refreshed within the
+// replicate interval.
+func (n *Node) republishDue(now time.Time) {
+	timeout := ADAPTIVE_TIMEOUT
+
+	// 1) Keys we originated get a fresh TTL before they expire, until they
+	// are removed here
+	n.pruneGone()
+	type due struct {
+		key string
+		pub *publication
+	}
+	var originals []due
+	n.pubMu.Lock()
+	for key, p := range n.published {
+		if now.Sub(p.lastPublished) >= n.republishEvery() {
+			originals = append(originals, due{key, p})
+		}
+	}
+	n.pubMu.Unlock()
+
+	for _, d := range originals {
+		if err := n.publishKey(n.lifetime, d.key, d.pub.value, n.Config.DefaultTTL, timeout); err != nil {
+			fmt.Printf("Republish %s failed: %v\n", d.key, err)
+			continue
+		}
+		if err := n.storeKeyLocal(d.key, d.pub.value, n.Config.DefaultTTL); err != nil {
+			fmt.Printf("Republish %s locally failed: %v\n", d.key, err)
+			if errors.Is(err, ErrStaleSeq) {
+				// A newer record was published elsewhere with our key
+				n.pubMu.Lock()
+				delete(n.published, d.key)
+				n.pubMu.Unlock()
+			}
+			continue
+		}
+		// Only a successful republish is a publication; failures retry next tick
+		n.pubMu.Lock()
+		if d.pub.lastPublished.Before(now) {
+			d.pub.lastPublished = now
+		}
+		n.pubMu.Unlock()
+		fmt.Printf("Republished %s\n", d.key)
+	}
+
+	// 2) Keys we merely hold are replicated with their remaining TTL, unless
+	// a STORE for them arrived (or we replicated them) within the interval.
+	replicationDue := func(rec *storage.Record) bool {
+		last := rec.StoredAt
+		if rec.ReplicatedAt.After(last) {
+			last = rec.ReplicatedAt
+		}
+		return !rec.Expired(now) && now.Sub(last) >= n.Config.ReplicateInterval
+	}
+	var holds []string
+	_ = n.store.Iterate(func(key string, rec storage.Record) bool {
+		n.pubMu.Lock()
+		_, mine := n.published[key]
+		n.pubMu.Unlock()
+		if !mine && replicationDue(&rec) {
+			holds = append(holds, key)
+		}
+		return true
+	})
+
+	for _, key := range holds {
+		// Re-check under the store's lock: a STORE since the scan may have
+		// refreshed the record, and must keep its later expiry
+		rec, ok, err := n.store.Touch(key, func(rec *storage.Record) bool {
+			if !replicationDue(rec) {
+				return false
+			}
+			rec.ReplicatedAt = now
+			return true
+		})
+		if err != nil {
+			fmt.Printf("Replicate %s failed: %v\n", key, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := n.publishKey(n.lifetime, key, rec.Value, rec.ExpiresAt.Sub(now), timeout); err != nil {
+			fmt.Printf("Replicate %s failed: %v\n", key, err)
+			continue
+		}
+		fmt.Printf("Replicated %s\n", key)
+	}
+}
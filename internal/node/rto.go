@@ -0,0 +1,132 @@
+package node
+
+import (
+	"net"
+	"time"
+)
+
+// INITIAL_RTO is the RPC timeout for a peer we have no RTT samples for.
+// MIN_RTO and MAX_RTO are the default floor and ceiling of any timeout.
+const (
+	INITIAL_RTO     = 800 * time.Millisecond
+	MIN_RTO         = 100 * time.Millisecond
+	MAX_RTO         = 5 * time.Second
+	RTO_GRANULARITY = 10 * time.Millisecond
+)
+
+// RTT_GAIN and RTTVAR_GAIN are the weights of a new sample in the smoothed
+// RTT and its mean deviation, as in TCP (RFC 6298)
+const (
+	RTT_GAIN    = 0.125
+	RTTVAR_GAIN = 0.25
+)
+
+// MAX_RTT_PEERS bounds how many peers' RTT estimates are remembered
+const MAX_RTT_PEERS = 4096
+
+// ADAPTIVE_TIMEOUT passed as an RPC timeout uses the peer's estimated RTO
+const ADAPTIVE_TIMEOUT time.Duration = 0
+
+// rttEstimate keeps the smoothed RTT and its mean deviation for one peer
+// address. backoff counts RPCs it failed to answer since its last reply.
+type rttEstimate struct {
+	srtt    time.Duration
+	rttvar  time.Duration
+	backoff int
+}
+
+// sample folds a reply that took rtt into the estimate
+func (e *rttEstimate) sample(rtt time.Duration) {
+	if e.srtt == 0 {
+		e.srtt, e.rttvar = rtt, rtt/2
+	} else {
+		diff := e.srtt - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		e.rttvar += time.Duration(RTTVAR_GAIN * float64(diff-e.rttvar))
+		e.srtt += time.Duration(RTT_GAIN * float64(rtt-e.srtt))
+	}
+	e.backoff = 0
+}
+
+// rto returns the timeout within [min, max], as in RFC 6298: the smoothed
+// RTT plus four deviations, doubled for every RPC that went unanswered
+// since the last reply.
+func (e rttEstimate) rto(min, max time.Duration) time.Duration {
+	rto := INITIAL_RTO
+	if e.srtt > 0 {
+		variance := 4 * e.rttvar
+		if variance < RTO_GRANULARITY {
+			variance = RTO_GRANULARITY
+		}
+		rto = e.srtt + variance
+	}
+	if rto < min {
+		rto = min
+	}
+	for i := 0; i < e.backoff && rto < max; i++ {
+		rto *= 2
+	}
+	if rto > max {
+		rto = max
+	}
+	return rto
+}
+
+// RTO returns the timeout RPCs to addr use when called with a zero timeout.
+// Estimates are kept per address whether or not the peer is in the routing
+// table, so bootstrap peers and lookup candidates get one too.
+func (n *Node) RTO(addr *net.UDPAddr) time.Duration {
+	n.rttMu.Lock()
+	defer n.rttMu.Unlock()
+	var e rttEstimate
+	if known := n.rtts[addr.String()]; known != nil {
+		e = *known
+	}
+	return e.rto(n.Config.MinRTO, n.Config.MaxRTO)
+}
+
+// SmoothedRTT returns the smoothed round trip time to addr, zero until a
+// reply from it has been measured
+func (n *Node) SmoothedRTT(addr *net.UDPAddr) time.Duration {
+	n.rttMu.Lock()
+	defer n.rttMu.Unlock()
+	if e := n.rtts[addr.String()]; e != nil {
+		return e.srtt
+	}
+	return 0
+}
+
+// estimate returns the entry for addr, creating it. Callers hold rttMu.
+func (n *Node) estimate(addr *net.UDPAddr) *rttEstimate {
+	key := addr.String()
+	e := n.rtts[key]
+	if e == nil {
+		if len(n.rtts) >= MAX_RTT_PEERS {
+			// Replies can come from any address, so make room rather than grow;
+			// a forgotten peer starts again from INITIAL_RTO
+			for k := range n.rtts {
+				delete(n.rtts, k)
+				break
+			}
+		}
+		e = &rttEstimate{}
+		n.rtts[key] = e
+	}
+	return e
+}
+
+// recordRTT updates the estimate for addr after a reply that took rtt
+func (n *Node) recordRTT(addr *net.UDPAddr, rtt time.Duration) {
+	n.rttMu.Lock()
+	defer n.rttMu.Unlock()
+	n.estimate(addr).sample(rtt)
+}
+
+// recordTimeout backs off the timeout for addr after an RPC went unanswered
+func (n *Node) recordTimeout(addr *net.UDPAddr) {
+	n.rttMu.Lock()
+	defer n.rttMu.Unlock()
+	n.estimate(addr).backoff++
+}
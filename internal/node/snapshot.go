@@ -0,0 +1,113 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+)
+
+// SNAPSHOT_FILE is the routing-table snapshot inside the data directory.
+const SNAPSHOT_FILE = "routing.json"
+
+// SNAPSHOT_INTERVAL is how often the routing table is saved to disk.
+const SNAPSHOT_INTERVAL = 5 * time.Minute
+
+// WARM_START_PINGS bounds how many snapshot contacts are pinged at once.
+const WARM_START_PINGS = 16
+
+// snapshotPath returns where the routing table is saved, or "" without a data dir
+func (n *Node) snapshotPath() string {
+	if n.Config.DataDir == "" {
+		return ""
+	}
+	return filepath.Join(n.Config.DataDir, SNAPSHOT_FILE)
+}
+
+// hasSnapshot reports whether a routing snapshot is there to rejoin through
+func (n *Node) hasSnapshot() bool {
+	path := n.snapshotPath()
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// saveSnapshot writes the routing table to the data directory, if any.
+func (n *Node) saveSnapshot() {
+	path := n.snapshotPath()
+	if path == "" {
+		return
+	}
+	if err := n.RoutingTable.SaveSnapshot(path); err != nil {
+		fmt.Printf("Routing snapshot failed: %v\n", err)
+	}
+}
+
+// runSnapshotter periodically saves the routing table until the node shuts down.
+func (n *Node) runSnapshotter() {
+	ticker := time.NewTicker(n.Config.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n.saveSnapshot()
+		case <-n.quit:
+			return
+		}
+	}
+}
+
+// warmStart pings every contact from the last snapshot and adds the ones
+// that answer to the routing table. It returns how many were reseeded.
+func (n *Node) warmStart(ctx context.Context) int {
+	path := n.snapshotPath()
+	if path == "" {
+		return 0
+	}
+	contacts, err := kademlia.LoadSnapshot(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("Routing snapshot unreadable: %v\n", err)
+		}
+		return 0
+	}
+	fmt.Printf("Reseeding from %d snapshot contact(s)\n", len(contacts))
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reseeded int
+	)
+	sem := make(chan struct{}, WARM_START_PINGS)
+	for _, c := range contacts {
+		if c.ID.Equals(&n.ID) {
+			continue
+		}
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			peerID, err := n.PingSync(ctx, &c.Address, ADAPTIVE_TIMEOUT)
+			if err != nil || !peerID.Equals(c.ID) {
+				return // gone, or the address now belongs to someone else
+			}
+			n.AddContact(kademlia.NewContactWithDistance(&n.ID, &c.Address, &peerID))
+
+			mu.Lock()
+			reseeded++
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	fmt.Printf("Reseeded %d live contact(s) from snapshot\n", reseeded)
+	return reseeded
+}
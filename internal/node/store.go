@@ -0,0 +1,213 @@
+package node
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/storage"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// DEFAULT_TTL is the lifetime given to values stored without an explicit TTL.
+const DEFAULT_TTL = 24 * time.Hour
+
+// MAX_TTL is the longest lifetime a node accepts for a stored value.
+const MAX_TTL = 48 * time.Hour
+
+// SWEEP_INTERVAL is how often expired values are removed from the store.
+const SWEEP_INTERVAL = time.Minute
+
+// MAX_VALUE_SIZE is the largest single value a peer may store here.
+const MAX_VALUE_SIZE = 4 << 10
+
+// MAX_STORE_BYTES is the total value bytes a node holds before evicting.
+const MAX_STORE_BYTES = 256 << 20
+
+// MAX_PUBLISHER_BYTES is the most value bytes one sending node may hold here.
+const MAX_PUBLISHER_BYTES = 16 << 20
+
+// EVICTION POLICIES
+const EVICT_FURTHEST = "furthest"
+const EVICT_LRU = "lru"
+
+// REFUSAL REASONS sent back in STORE_REFUSED
+const REFUSE_TOO_LARGE = "too_large"
+const REFUSE_QUOTA = "quota"
+const REFUSE_FULL = "full"
+const REFUSE_STALE_SEQ = "stale_seq"
+const REFUSE_BAD_SIGNATURE = "bad_signature"
+
+// ErrStoreRefused is returned when a peer answers a store with STORE_REFUSED
+var ErrStoreRefused = errors.New("store refused")
+
+// refusalReason maps a failed store to the reason reported to the sender, or
+// "" if the failure is local trouble rather than a refusal.
+func refusalReason(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrValueTooLarge):
+		return REFUSE_TOO_LARGE
+	case errors.Is(err, storage.ErrPublisherQuota):
+		return REFUSE_QUOTA
+	case errors.Is(err, storage.ErrStoreFull):
+		return REFUSE_FULL
+	case errors.Is(err, ErrStaleSeq):
+		return REFUSE_STALE_SEQ
+	case errors.Is(err, ErrBadSignature):
+		return REFUSE_BAD_SIGNATURE
+	}
+	return ""
+}
+
+// storeReply answers a STORE or PUT_MUTABLE with STORED, or STORE_REFUSED
+// <myID> <key> <reason> when err is a refusal.
+func (n *Node) storeReply(msg kadnet.Message, key string, err error) (*kadnet.Message, error) {
+	if err == nil {
+		return &kadnet.Message{
+			Type:  kadnet.MSG_STORED,
+			RPCID: msg.RPCID,
+			Args:  []string{n.ID.String(), key},
+		}, nil
+	}
+	reason := refusalReason(err)
+	if reason == "" {
+		return nil, fmt.Errorf("%s %s: %w", msg.Type, key, err)
+	}
+	fmt.Printf("Refused %s %s from %s: %v\n", msg.Type, key, msg.Args[0], err)
+	return &kadnet.Message{
+		Type:  kadnet.MSG_STORE_REFUSED,
+		RPCID: msg.RPCID,
+		Args:  []string{n.ID.String(), key, reason},
+	}, nil
+}
+
+// openStore returns the storage backend selected by the config, wrapped in
+// the configured limits
+func openStore(config NodeConfig) (storage.Store, error) {
+	var evict storage.EvictionPolicy
+	switch config.EvictionPolicy {
+	case EVICT_FURTHEST:
+		evict = evictFurthest(config.ID)
+	case EVICT_LRU:
+		evict = storage.EvictLRU
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", config.EvictionPolicy)
+	}
+
+	var inner storage.Store = storage.NewMemoryStore()
+	if config.DataDir != "" {
+		fs, err := storage.OpenFileStore(config.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		inner = fs
+	}
+
+	limits := storage.Limits{
+		MaxValueSize:      config.MaxValueSize,
+		MaxBytes:          config.MaxStoreBytes,
+		MaxPublisherBytes: config.MaxPublisherBytes,
+	}
+	s, err := storage.NewLimitedStore(inner, limits, evict)
+	if err != nil {
+		inner.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// evictFurthest evicts keys furthest from self first: those are the keys
+// other nodes are more responsible for.
+func evictFurthest(self util.ID) storage.EvictionPolicy {
+	return storage.EvictionPolicy{
+		Rank: func(key string) []byte {
+			key = strings.TrimPrefix(strings.TrimPrefix(key, MUTABLE_PREFIX), MANIFEST_PREFIX)
+			id, err := util.ParseHexID(key)
+			if err != nil {
+				// Not a DHT key, so nobody else will serve it either
+				return nil
+			}
+			return self.CalcDistance(&id).Bytes()
+		},
+		Before: func(a, b storage.Candidate) bool {
+			return bytes.Compare(a.Rank, b.Rank) > 0
+		},
+	}
+}
+
+// clampTTL replaces a missing TTL with the default and caps it at the max TTL
+func (n *Node) clampTTL(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		ttl = n.Config.DefaultTTL
+	}
+	if ttl > n.Config.MaxTTL {
+		ttl = n.Config.MaxTTL
+	}
+	return ttl
+}
+
+// storeLocal saves value under keyHex, expiring after ttl. publisher is the
+// hex ID of the sending node, or empty for values this node stores itself.
+func (n *Node) storeLocal(keyHex string, value []byte, ttl time.Duration, publisher string) error {
+	now := time.Now()
+	return n.store.Put(keyHex, storage.Record{
+		Value:     value,
+		StoredAt:  now,
+		ExpiresAt: now.Add(n.clampTTL(ttl)),
+		Publisher: publisher,
+	})
+}
+
+// loadLocal returns the value stored under keyHex unless it is missing or expired.
+func (n *Node) loadLocal(keyHex string) ([]byte, bool) {
+	rec, ok, err := n.store.Get(keyHex)
+	if err != nil {
+		fmt.Printf("Store read %s failed: %v\n", keyHex, err)
+		return nil, false
+	}
+	if !ok || rec.Expired(time.Now()) {
+		return nil, false
+	}
+	return rec.Value, true
+}
+
+// sweepExpired deletes every expired entry and returns how many were removed.
+func (n *Node) sweepExpired() int {
+	now := time.Now()
+
+	var expired []string
+	_ = n.store.Iterate(func(key string, rec storage.Record) bool {
+		if rec.Expired(now) {
+			expired = append(expired, key)
+		}
+		return true
+	})
+
+	removed := 0
+	for _, key := range expired {
+		if err := n.store.Delete(key); err == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// runSweeper periodically removes expired values until the node shuts down.
+func (n *Node) runSweeper() {
+	ticker := time.NewTicker(n.Config.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if removed := n.sweepExpired(); removed > 0 {
+				fmt.Printf("Swept %d expired value(s)\n", removed)
+			}
+		case <-n.quit:
+			return
+		}
+	}
+}
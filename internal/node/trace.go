@@ -0,0 +1,163 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// Outcomes of a traced query
+const (
+	TRACE_NODES     = "nodes"     // replied with contacts
+	TRACE_VALUE     = "value"     // replied with the value
+	TRACE_MISMATCH  = "mismatch"  // replied with a value not matching the key
+	TRACE_TIMEOUT   = "timeout"   // did not reply in time
+	TRACE_ERROR     = "error"     // failed otherwise
+	TRACE_ABANDONED = "abandoned" // still in flight when the lookup ended
+)
+
+// Trace collects a record of every lookup run under a context returned by
+// WithTrace. An operation such as a chunked Get may run several lookups,
+// in parallel.
+type Trace struct {
+	mu      sync.Mutex
+	lookups []*LookupTrace
+}
+
+// Lookups returns the lookups traced so far, in the order they started
+func (t *Trace) Lookups() []*LookupTrace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*LookupTrace(nil), t.lookups...)
+}
+
+// LookupTrace records one iterative lookup query by query. Durations are
+// in nanoseconds when encoded as JSON.
+type LookupTrace struct {
+	Kind     string        `json:"kind"` // FIND_NODE or FIND_VALUE
+	Target   string        `json:"target"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	// Result is how the lookup ended: "converged", "exhausted", "found" or
+	// the error of the context that ended it
+	Result   string       `json:"result"`
+	Queries  []TraceQuery `json:"queries"`
+	Contacts []string     `json:"contacts"` // closest found, as id@host:port
+}
+
+// TraceQuery is one RPC of a lookup
+type TraceQuery struct {
+	// Round is 1 for contacts from our own table, and one more than the
+	// round of the reply that named the contact otherwise
+	Round    int           `json:"round"`
+	ID       string        `json:"id"`
+	Addr     string        `json:"addr"`
+	Distance string        `json:"distance"` // XOR distance to the target
+	Prefix   int           `json:"prefix"`   // leading bits shared with the target
+	Sent     time.Duration `json:"sent"`     // since the lookup started
+	RTT      time.Duration `json:"rtt"`
+	Outcome  string        `json:"outcome"`
+	Error    string        `json:"error,omitempty"`
+	Returned int           `json:"returned"`          // contacts in the reply
+	Learned  []string      `json:"learned,omitempty"` // of those, new to the lookup
+	// Closest is how many leading bits the closest contact known after the
+	// reply shares with the target, -1 if none is known
+	Closest int `json:"closest"`
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context under which IterativeFindNode,
+// IterativeFindValue and everything built on them record into t
+func WithTrace(ctx context.Context, t *Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// traceFrom returns the trace attached to ctx, or nil
+func traceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// begin starts recording a lookup; on a nil trace it returns nil, which
+// every LookupTrace method below ignores
+func (t *Trace) begin(kind string, target util.ID) *LookupTrace {
+	if t == nil {
+		return nil
+	}
+	lt := &LookupTrace{Kind: kind, Target: target.String(), Start: time.Now()}
+	t.mu.Lock()
+	t.lookups = append(t.lookups, lt)
+	t.mu.Unlock()
+	return lt
+}
+
+// sent records a query to c in round
+func (lt *LookupTrace) sent(round int, c kademlia.Contact, target util.ID) {
+	if lt == nil {
+		return
+	}
+	lt.Queries = append(lt.Queries, TraceQuery{
+		Round:    round,
+		ID:       c.ID.String(),
+		Addr:     c.Address.String(),
+		Distance: c.ID.CalcDistance(&target).String(),
+		Prefix:   c.ID.CommonPrefixLen(&target),
+		Sent:     time.Since(lt.Start),
+		Outcome:  TRACE_ABANDONED,
+		Closest:  -1,
+	})
+}
+
+// replied fills in the query to r.from with its reply, the contacts it
+// taught the lookup and the closest contact known afterwards
+func (lt *LookupTrace) replied(r lookupReply, learned []kademlia.Contact, closest *kademlia.Contact, target util.ID) {
+	if lt == nil {
+		return
+	}
+	id := r.from.ID.String()
+	for i := len(lt.Queries) - 1; i >= 0; i-- {
+		q := &lt.Queries[i]
+		if q.ID != id {
+			continue
+		}
+		q.RTT = r.rtt
+		q.Returned = len(r.contacts)
+		switch {
+		case errors.Is(r.err, ErrHashMismatch):
+			q.Outcome = TRACE_MISMATCH
+		case errors.Is(r.err, context.DeadlineExceeded):
+			q.Outcome = TRACE_TIMEOUT
+		case r.err != nil:
+			q.Outcome = TRACE_ERROR
+		case r.found:
+			q.Outcome = TRACE_VALUE
+		default:
+			q.Outcome = TRACE_NODES
+		}
+		if r.err != nil {
+			q.Error = r.err.Error()
+		}
+		for _, c := range learned {
+			q.Learned = append(q.Learned, kademlia.EncodeContactToken(c))
+		}
+		if closest != nil {
+			q.Closest = closest.ID.CommonPrefixLen(&target)
+		}
+		return
+	}
+}
+
+// finish records how the lookup ended and what it found
+func (lt *LookupTrace) finish(result string, contacts []kademlia.Contact) {
+	if lt == nil {
+		return
+	}
+	lt.Duration = time.Since(lt.Start)
+	lt.Result = result
+	lt.Contacts = kademlia.EncodeContactsForArgs(contacts)
+}
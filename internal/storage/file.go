@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// LOG_FILE is the name of the append-only log inside the data directory
+const LOG_FILE = "values.log"
+
+// COMPACT_THRESHOLD is the number of dead log bytes tolerated before compaction
+const COMPACT_THRESHOLD = 1 << 20
+
+const (
+	opPut    byte = 1
+	opDelete byte = 2
+)
+
+// MAX_KEY_SIZE and MAX_ENTRY_VALUE bound lengths read back from the log so a
+// corrupt header cannot trigger a huge allocation
+const MAX_KEY_SIZE = 1 << 10
+const MAX_ENTRY_VALUE = 1 << 30
+
+// MAX_PUBLISHER_SIZE is the longest publisher an entry's length byte can hold
+const MAX_PUBLISHER_SIZE = 255
+
+// crc(4) op(1) keyLen(4) storedAt(8) expiresAt(8) replicatedAt(8) valueLen(4)
+// publisherLen(1) before the key, publisher and value
+const headerSize = 4 + 1 + 4 + 8 + 8 + 8 + 4 + 1
+
+// indexEntry locates a record's value in the log and caches its metadata
+type indexEntry struct {
+	entryOff  int64 // start of the log entry
+	entrySize int64 // size of the whole log entry
+	valueOff  int64 // start of the value bytes
+	valueLen  int
+	rec       Record // metadata only, Value is nil
+}
+
+// FileStore keeps records in an append-only log with an in-memory index.
+// Every Put and Delete appends an entry; the log is rewritten once dead
+// entries outweigh live ones.
+type FileStore struct {
+	mu    sync.RWMutex
+	dir   string
+	file  *os.File
+	size  int64
+	index map[string]indexEntry
+	live  int64 // bytes of log entries still referenced by the index
+	bytes int64 // value bytes referenced by the index
+}
+
+// OpenFileStore opens (or creates) the log in dir and rebuilds its index.
+func OpenFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create data dir: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, LOG_FILE), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open log: %w", err)
+	}
+
+	s := &FileStore{dir: dir, file: f, index: make(map[string]indexEntry)}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// load scans the log, rebuilding the index and dropping a torn tail.
+func (s *FileStore) load() error {
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(s.file)
+
+	var off int64
+	for {
+		key, rec, op, size, err := readEntry(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				fmt.Printf("Truncating %s at offset %d: %v\n", LOG_FILE, off, err)
+			}
+			break
+		}
+		s.apply(key, op, indexEntry{
+			entryOff:  off,
+			entrySize: size,
+			valueOff:  off + size - int64(len(rec.Value)),
+			valueLen:  len(rec.Value),
+			rec:       metadata(rec),
+		})
+		off += size
+	}
+
+	// Drop anything after the last valid entry so appends stay aligned
+	if err := s.file.Truncate(off); err != nil {
+		return fmt.Errorf("truncate log: %w", err)
+	}
+	s.size = off
+	return nil
+}
+
+// apply updates the index for a log entry; callers hold s.mu.
+func (s *FileStore) apply(key string, op byte, e indexEntry) {
+	if old, ok := s.index[key]; ok {
+		s.live -= old.entrySize
+		s.bytes -= int64(old.valueLen)
+		delete(s.index, key)
+	}
+	if op != opDelete {
+		s.index[key] = e
+		s.live += e.entrySize
+		s.bytes += int64(e.valueLen)
+	}
+}
+
+// Get reads the value for key from the log
+func (s *FileStore) Get(key string) (Record, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.index[key]
+	if !ok {
+		return Record{}, false, nil
+	}
+	rec := e.rec
+	rec.Value = make([]byte, e.valueLen)
+	if _, err := s.file.ReadAt(rec.Value, e.valueOff); err != nil {
+		return Record{}, false, fmt.Errorf("read %s: %w", key, err)
+	}
+	return rec, true, nil
+}
+
+// Put appends rec to the log and points the index at it
+func (s *FileStore) Put(key string, rec Record) error {
+	if len(rec.Publisher) > MAX_PUBLISHER_SIZE {
+		return fmt.Errorf("publisher %q too long", rec.Publisher)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(key, opPut, rec); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+// Touch appends the record under key again with the times fn set on it
+func (s *FileStore) Touch(key string, fn func(rec *Record) bool) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.index[key]
+	if !ok {
+		return Record{}, false, nil
+	}
+	changed := e.rec
+	if !fn(&changed) {
+		return Record{}, false, nil
+	}
+	rec := e.rec
+	setTimes(&rec, changed)
+	rec.Value = make([]byte, e.valueLen)
+	if _, err := s.file.ReadAt(rec.Value, e.valueOff); err != nil {
+		return Record{}, false, fmt.Errorf("read %s: %w", key, err)
+	}
+	if err := s.append(key, opPut, rec); err != nil {
+		return Record{}, false, err
+	}
+	return rec, true, s.maybeCompact()
+}
+
+// Delete appends a tombstone for key
+func (s *FileStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.index[key]; !ok {
+		return nil
+	}
+	if err := s.append(key, opDelete, Record{}); err != nil {
+		return err
+	}
+	return s.maybeCompact()
+}
+
+// Iterate calls fn for every record, reading values from the log
+func (s *FileStore) Iterate(fn func(key string, rec Record) bool) error {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.index))
+	for k := range s.index {
+		keys = append(keys, k)
+	}
+	s.mu.RUnlock()
+
+	for _, k := range keys {
+		rec, ok, err := s.Get(k)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue // deleted since the snapshot
+		}
+		if !fn(k, rec) {
+			break
+		}
+	}
+	return nil
+}
+
+// Stats returns the number of keys and value bytes held
+func (s *FileStore) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Stats{Keys: len(s.index), Bytes: s.bytes}
+}
+
+// Close flushes the log to disk and closes it
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	return err
+}
+
+// append writes one entry at the end of the log and syncs it; callers hold s.mu.
+func (s *FileStore) append(key string, op byte, rec Record) error {
+	if s.file == nil {
+		return fmt.Errorf("store closed")
+	}
+	buf := encodeEntry(key, op, rec)
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		return fmt.Errorf("append log: %w", err)
+	}
+	// A write the caller was told succeeded must survive a crash
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("sync log: %w", err)
+	}
+
+	e := indexEntry{
+		entryOff:  s.size,
+		entrySize: int64(len(buf)),
+		valueOff:  s.size + int64(len(buf)-len(rec.Value)),
+		valueLen:  len(rec.Value),
+		rec:       metadata(rec),
+	}
+	s.size += int64(len(buf))
+	s.apply(key, op, e)
+	return nil
+}
+
+// maybeCompact rewrites the log once dead entries outweigh live ones.
+func (s *FileStore) maybeCompact() error {
+	dead := s.size - s.live
+	if dead < COMPACT_THRESHOLD || dead < s.live {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact writes every live entry to a fresh log and swaps it in; callers hold s.mu.
+func (s *FileStore) compact() error {
+	path := filepath.Join(s.dir, LOG_FILE)
+	tmp, err := os.OpenFile(path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+
+	index := make(map[string]indexEntry, len(s.index))
+	var off int64
+	for key, e := range s.index {
+		buf := make([]byte, e.entrySize)
+		if _, err := s.file.ReadAt(buf, e.entryOff); err != nil {
+			tmp.Close()
+			return fmt.Errorf("compact read %s: %w", key, err)
+		}
+		if _, err := tmp.WriteAt(buf, off); err != nil {
+			tmp.Close()
+			return fmt.Errorf("compact write %s: %w", key, err)
+		}
+		e.valueOff = off + (e.valueOff - e.entryOff)
+		e.entryOff = off
+		index[key] = e
+		off += e.entrySize
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("compact sync: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("compact rename: %w", err)
+	}
+
+	s.file.Close()
+	s.file = tmp
+	s.index = index
+	s.size = off
+	s.live = off
+
+	// The rename only survives a crash once the directory is synced too
+	if err := syncDir(s.dir); err != nil {
+		return fmt.Errorf("compact sync dir: %w", err)
+	}
+	return nil
+}
+
+// syncDir flushes the directory entries in dir to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// metadata returns rec without its value, as cached in the index
+func metadata(rec Record) Record {
+	rec.Value = nil
+	return rec
+}
+
+// encodeEntry serialises one log entry including its checksum.
+func encodeEntry(key string, op byte, rec Record) []byte {
+	pub := rec.Publisher
+	buf := make([]byte, headerSize+len(key)+len(pub)+len(rec.Value))
+	buf[4] = op
+	binary.BigEndian.PutUint32(buf[5:], uint32(len(key)))
+	binary.BigEndian.PutUint64(buf[9:], uint64(encodeTime(rec.StoredAt)))
+	binary.BigEndian.PutUint64(buf[17:], uint64(encodeTime(rec.ExpiresAt)))
+	binary.BigEndian.PutUint64(buf[25:], uint64(encodeTime(rec.ReplicatedAt)))
+	binary.BigEndian.PutUint32(buf[33:], uint32(len(rec.Value)))
+	buf[37] = byte(len(pub))
+	off := headerSize
+	off += copy(buf[off:], key)
+	off += copy(buf[off:], pub)
+	copy(buf[off:], rec.Value)
+	binary.BigEndian.PutUint32(buf[0:], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// readEntry reads one log entry and verifies its checksum.
+func readEntry(r io.Reader) (string, Record, byte, int64, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", Record{}, 0, 0, fmt.Errorf("short header")
+		}
+		return "", Record{}, 0, 0, err
+	}
+
+	op := hdr[4]
+	if op != opPut && op != opDelete {
+		return "", Record{}, 0, 0, fmt.Errorf("unknown op %d", op)
+	}
+
+	keyLen := binary.BigEndian.Uint32(hdr[5:])
+	valLen := binary.BigEndian.Uint32(hdr[33:])
+	pubLen := hdr[37]
+	if keyLen > MAX_KEY_SIZE || valLen > MAX_ENTRY_VALUE {
+		return "", Record{}, 0, 0, fmt.Errorf("implausible entry lengths")
+	}
+	body := make([]byte, int(keyLen)+int(pubLen)+int(valLen))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", Record{}, 0, 0, fmt.Errorf("short entry")
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(hdr[0:]) {
+		return "", Record{}, 0, 0, fmt.Errorf("checksum mismatch")
+	}
+
+	pubEnd := keyLen + uint32(pubLen)
+	rec := Record{
+		StoredAt:     decodeTime(int64(binary.BigEndian.Uint64(hdr[9:]))),
+		ExpiresAt:    decodeTime(int64(binary.BigEndian.Uint64(hdr[17:]))),
+		ReplicatedAt: decodeTime(int64(binary.BigEndian.Uint64(hdr[25:]))),
+		Publisher:    string(body[keyLen:pubEnd]),
+		Value:        body[pubEnd:],
+	}
+	return string(body[:keyLen]), rec, op, int64(headerSize + len(body)), nil
+}
+
+// encodeTime maps the zero time to 0 so it survives a round trip
+func encodeTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func decodeTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
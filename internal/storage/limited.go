@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrValueTooLarge is returned for a value above Limits.MaxValueSize
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrPublisherQuota is returned when a publisher would exceed Limits.MaxPublisherBytes
+	ErrPublisherQuota = errors.New("publisher over quota")
+	// ErrStoreFull is returned when eviction cannot free enough room
+	ErrStoreFull = errors.New("store full")
+)
+
+// Limits bound what a LimitedStore accepts. Zero fields are unlimited.
+type Limits struct {
+	MaxValueSize int
+	MaxBytes     int64
+	// MaxPublisherBytes caps the value bytes held per Record.Publisher.
+	// Records without a publisher are not counted.
+	MaxPublisherBytes int64
+}
+
+// Candidate describes a stored record considered for eviction
+type Candidate struct {
+	Key       string
+	Size      int
+	Publisher string
+	// LastUsed is the last Get or Put of the key, or its StoredAt if it has
+	// not been touched since the store was opened
+	LastUsed time.Time
+	// Rank is what the policy's Rank returned for Key, if it has one
+	Rank []byte
+}
+
+// EvictionPolicy orders the records a LimitedStore may evict
+type EvictionPolicy struct {
+	// Before reports whether a should be evicted before b
+	Before func(a, b Candidate) bool
+	// Rank, if set, is computed once per stored key so Before can compare
+	// it instead of working it out from the key on every comparison
+	Rank func(key string) []byte
+}
+
+// EvictLRU evicts the least recently used record first
+var EvictLRU = EvictionPolicy{
+	Before: func(a, b Candidate) bool { return a.LastUsed.Before(b.LastUsed) },
+}
+
+// LimitedStore wraps a Store and enforces Limits on Put. When MaxBytes would
+// be exceeded it evicts records in the order given by its policy; a new
+// record the policy ranks below the evicted ones is refused instead.
+// Records without a publisher are the node's own and are never evicted.
+type LimitedStore struct {
+	Store
+	limits Limits
+	evict  EvictionPolicy
+
+	mu        sync.Mutex
+	meta      map[string]*held
+	queue     evictQueue       // records with a publisher, next victim first
+	published map[string]int64 // value bytes held per publisher
+}
+
+// held is the accounting for one stored key
+type held struct {
+	Candidate
+	index int // position in the eviction queue, -1 if not in it
+}
+
+// NewLimitedStore wraps inner, reading its records to rebuild the accounting.
+// A policy without Before evicts LRU.
+func NewLimitedStore(inner Store, limits Limits, evict EvictionPolicy) (*LimitedStore, error) {
+	if evict.Before == nil {
+		evict = EvictLRU
+	}
+	s := &LimitedStore{
+		Store:     inner,
+		limits:    limits,
+		evict:     evict,
+		meta:      make(map[string]*held),
+		queue:     evictQueue{before: evict.Before},
+		published: make(map[string]int64),
+	}
+	err := inner.Iterate(func(key string, rec Record) bool {
+		s.account(key, s.candidate(key, rec, rec.StoredAt))
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan store: %w", err)
+	}
+	return s, nil
+}
+
+// candidate describes rec under key as last used at usedAt
+func (s *LimitedStore) candidate(key string, rec Record, usedAt time.Time) Candidate {
+	c := Candidate{Key: key, Size: len(rec.Value), Publisher: rec.Publisher, LastUsed: usedAt}
+	if s.evict.Rank != nil {
+		c.Rank = s.evict.Rank(key)
+	}
+	return c
+}
+
+// Get returns the record under key and marks it as used
+func (s *LimitedStore) Get(key string) (Record, bool, error) {
+	rec, ok, err := s.Store.Get(key)
+	if ok {
+		s.mu.Lock()
+		if h, ok := s.meta[key]; ok {
+			h.LastUsed = time.Now()
+			if h.index >= 0 {
+				heap.Fix(&s.queue, h.index)
+			}
+		}
+		s.mu.Unlock()
+	}
+	return rec, ok, err
+}
+
+// Put stores rec if it fits the limits, evicting other records if needed
+func (s *LimitedStore) Put(key string, rec Record) error {
+	if s.limits.MaxValueSize > 0 && len(rec.Value) > s.limits.MaxValueSize {
+		return fmt.Errorf("%w: %d bytes, max %d", ErrValueTooLarge, len(rec.Value), s.limits.MaxValueSize)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	incoming := s.candidate(key, rec, time.Now())
+	var old Candidate
+	if h, ok := s.meta[key]; ok {
+		old = h.Candidate
+	}
+
+	if pub := rec.Publisher; pub != "" && s.limits.MaxPublisherBytes > 0 {
+		used := s.published[pub] + int64(incoming.Size)
+		if old.Publisher == pub {
+			used -= int64(old.Size)
+		}
+		if used > s.limits.MaxPublisherBytes {
+			return fmt.Errorf("%w: %s would hold %d bytes, max %d", ErrPublisherQuota, pub, used, s.limits.MaxPublisherBytes)
+		}
+	}
+
+	victims, err := s.victims(incoming, int64(old.Size))
+	if err != nil {
+		return err
+	}
+	for _, v := range victims {
+		if err := s.Store.Delete(v.Key); err != nil {
+			return fmt.Errorf("evict %s: %w", v.Key, err)
+		}
+		s.unaccount(v.Key)
+	}
+
+	if err := s.Store.Put(key, rec); err != nil {
+		return err
+	}
+	s.unaccount(key)
+	s.account(key, incoming)
+	return nil
+}
+
+// Delete removes key and its accounting
+func (s *LimitedStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.Store.Delete(key); err != nil {
+		return err
+	}
+	s.unaccount(key)
+	return nil
+}
+
+// PublisherBytes returns the value bytes held for publisher
+func (s *LimitedStore) PublisherBytes(publisher string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.published[publisher]
+}
+
+// victims picks the records to evict so that incoming fits in MaxBytes,
+// replacing oldSize bytes under the same key; callers hold s.mu.
+func (s *LimitedStore) victims(incoming Candidate, oldSize int64) ([]Candidate, error) {
+	if s.limits.MaxBytes <= 0 {
+		return nil, nil
+	}
+	need := s.Store.Stats().Bytes - oldSize + int64(incoming.Size) - s.limits.MaxBytes
+	if need <= 0 {
+		return nil, nil
+	}
+
+	// Pop candidates in eviction order and put them all back afterwards;
+	// the caller unaccounts the ones it actually deletes
+	var popped []*held
+	defer func() {
+		for _, h := range popped {
+			heap.Push(&s.queue, h)
+		}
+	}()
+
+	var victims []Candidate
+	for need > 0 {
+		if s.queue.Len() == 0 {
+			return nil, fmt.Errorf("%w: %d bytes over %d", ErrStoreFull, need, s.limits.MaxBytes)
+		}
+		h := heap.Pop(&s.queue).(*held)
+		popped = append(popped, h)
+		if h.Key == incoming.Key {
+			continue // about to be replaced anyway
+		}
+		if incoming.Publisher != "" && s.evict.Before(incoming, h.Candidate) {
+			// Everything left is worth keeping more than the new record
+			return nil, fmt.Errorf("%w: %d bytes over %d", ErrStoreFull, need, s.limits.MaxBytes)
+		}
+		victims = append(victims, h.Candidate)
+		need -= int64(h.Size)
+	}
+	return victims, nil
+}
+
+// account records c under key; callers hold s.mu.
+func (s *LimitedStore) account(key string, c Candidate) {
+	h := &held{Candidate: c, index: -1}
+	s.meta[key] = h
+	if c.Publisher != "" {
+		heap.Push(&s.queue, h)
+		s.published[c.Publisher] += int64(c.Size)
+	}
+}
+
+// unaccount forgets key; callers hold s.mu.
+func (s *LimitedStore) unaccount(key string) {
+	h, ok := s.meta[key]
+	if !ok {
+		return
+	}
+	delete(s.meta, key)
+	if h.index >= 0 {
+		heap.Remove(&s.queue, h.index)
+	}
+	if h.Publisher != "" {
+		if s.published[h.Publisher] -= int64(h.Size); s.published[h.Publisher] <= 0 {
+			delete(s.published, h.Publisher)
+		}
+	}
+}
+
+// evictQueue is a heap of held records ordered by an eviction policy
+type evictQueue struct {
+	items  []*held
+	before func(a, b Candidate) bool
+}
+
+func (q evictQueue) Len() int           { return len(q.items) }
+func (q evictQueue) Less(i, j int) bool { return q.before(q.items[i].Candidate, q.items[j].Candidate) }
+
+func (q evictQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *evictQueue) Push(x any) {
+	h := x.(*held)
+	h.index = len(q.items)
+	q.items = append(q.items, h)
+}
+
+func (q *evictQueue) Pop() any {
+	h := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	h.index = -1
+	return h
+}
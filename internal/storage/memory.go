@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"sync"
+)
+
+// MemoryStore keeps records in a map and loses them when the process exits
+type MemoryStore struct {
+	mu      sync.RWMutex
+	records map[string]Record
+	bytes   int64
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+// Get returns a copy of the record stored under key
+func (s *MemoryStore) Get(key string) (Record, bool, error) {
+	s.mu.RLock()
+	rec, ok := s.records[key]
+	s.mu.RUnlock()
+	if !ok {
+		return Record{}, false, nil
+	}
+	rec.Value = cloneBytes(rec.Value)
+	return rec, true, nil
+}
+
+// Put stores a copy of rec under key
+func (s *MemoryStore) Put(key string, rec Record) error {
+	rec.Value = cloneBytes(rec.Value)
+
+	s.mu.Lock()
+	if old, ok := s.records[key]; ok {
+		s.bytes -= int64(len(old.Value))
+	}
+	s.records[key] = rec
+	s.bytes += int64(len(rec.Value))
+	s.mu.Unlock()
+	return nil
+}
+
+// Touch updates the times of the record under key while holding the lock
+func (s *MemoryStore) Touch(key string, fn func(rec *Record) bool) (Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.records[key]
+	if !ok {
+		return Record{}, false, nil
+	}
+	changed := rec
+	if !fn(&changed) {
+		return Record{}, false, nil
+	}
+	setTimes(&rec, changed)
+	s.records[key] = rec
+	rec.Value = cloneBytes(rec.Value)
+	return rec, true, nil
+}
+
+// Delete removes key from the store
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	if old, ok := s.records[key]; ok {
+		s.bytes -= int64(len(old.Value))
+		delete(s.records, key)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Iterate calls fn for a snapshot of the stored records
+func (s *MemoryStore) Iterate(fn func(key string, rec Record) bool) error {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.records))
+	recs := make([]Record, 0, len(s.records))
+	for k, r := range s.records {
+		keys = append(keys, k)
+		recs = append(recs, r)
+	}
+	s.mu.RUnlock()
+
+	for i := range keys {
+		if !fn(keys[i], recs[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+// Stats returns the number of keys and value bytes held
+func (s *MemoryStore) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return Stats{Keys: len(s.records), Bytes: s.bytes}
+}
+
+// Close is a no-op for the in-memory store
+func (s *MemoryStore) Close() error { return nil }
+
+func cloneBytes(b []byte) []byte {
+	out := make([]byte, len(b))
+	copy(out, b)
+	return out
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"time"
+)
+
+// Record is a stored value together with its lifetime metadata
+type Record struct {
+	Value     []byte
+	StoredAt  time.Time
+	ExpiresAt time.Time
+	// ReplicatedAt is when the holder last pushed the record to its k closest
+	ReplicatedAt time.Time
+	// Publisher is the hex ID of the node that sent the record, empty for
+	// records this node stored itself
+	Publisher string
+}
+
+// Expired returns true if the record is past its TTL at the given time
+func (r Record) Expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
+
+// setTimes copies the time fields of from onto rec
+func setTimes(rec *Record, from Record) {
+	rec.StoredAt = from.StoredAt
+	rec.ExpiresAt = from.ExpiresAt
+	rec.ReplicatedAt = from.ReplicatedAt
+}
+
+// Stats summarises the contents of a Store
+type Stats struct {
+	Keys  int
+	Bytes int64
+}
+
+// Store is the interface a node uses to keep values between RPCs.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the record stored under key and whether it exists
+	Get(key string) (Record, bool, error)
+	// Put stores rec under key, replacing any previous record
+	Put(key string, rec Record) error
+	// Touch atomically passes the record under key to fn and, if fn returns
+	// true, keeps the times fn set on it. Value and Publisher cannot be
+	// changed. It returns the record as stored and whether fn applied.
+	Touch(key string, fn func(rec *Record) bool) (Record, bool, error)
+	// Delete removes key; deleting a missing key is not an error
+	Delete(key string) error
+	// Iterate calls fn for every record until fn returns false
+	Iterate(fn func(key string, rec Record) bool) error
+	// Stats returns the number of keys and value bytes held
+	Stats() Stats
+	// Close releases any resources held by the store
+	Close() error
+}
@@ -1,20 +1,40 @@
 package util
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math/bits"
 )
 
-const IDBytes = 20 // 160-bit
+const IDBytes = 20 // 160-bit, keyed by SHA-1
 
-type ID [IDBytes]byte
+const WIDE_ID_BYTES = 32 // 256-bit, keyed by SHA-256
+
+// ID is a node ID or key of IDBytes or WIDE_ID_BYTES bytes. The zero value
+// is the all-zero 160-bit ID; IDs of different widths are never equal.
+type ID struct {
+	b    [WIDE_ID_BYTES]byte
+	wide bool
+}
+
+// ValidIDBytes reports whether n is a supported ID width in bytes
+func ValidIDBytes(n int) bool {
+	return n == IDBytes || n == WIDE_ID_BYTES
+}
 
 // NewRandomID returns a cryptographically random 160-bit ID.
 func NewRandomID() ID {
-	var id ID
-	if _, err := rand.Read(id[:]); err != nil {
+	return NewRandomIDLen(IDBytes)
+}
+
+// NewRandomIDLen returns a cryptographically random ID of n bytes.
+func NewRandomIDLen(n int) ID {
+	id := zeroID(n)
+	if _, err := rand.Read(id.b[:n]); err != nil {
 		panic(fmt.Errorf("rand.Read: %w", err))
 	}
 	return id
@@ -22,58 +42,144 @@ func NewRandomID() ID {
 
 // NewIDFromSeed deterministically derives a 160-bit ID from a seed string.
 func NewIDFromSeed(seed string) ID {
+	return NewIDFromSeedLen(seed, IDBytes)
+}
+
+// NewIDFromSeedLen deterministically derives an ID of n bytes from a seed string.
+func NewIDFromSeedLen(seed string, n int) ID {
 	sum := sha256.Sum256([]byte(seed)) // 32 bytes
-	var id ID
-	copy(id[:], sum[:IDBytes]) // take first 20 bytes
+	id := zeroID(n)
+	copy(id.b[:n], sum[:]) // take the first n bytes
+	return id
+}
+
+// HashID returns the key data is stored under in a network of n-byte IDs:
+// its SHA-1 for 160-bit IDs and its SHA-256 for 256-bit ones.
+func HashID(data []byte, n int) ID {
+	if n == WIDE_ID_BYTES {
+		sum := sha256.Sum256(data)
+		id, _ := IDFromBytes(sum[:])
+		return id
+	}
+	sum := sha1.Sum(data)
+	id, _ := IDFromBytes(sum[:])
 	return id
 }
 
-// ParseHexID parses a 40-char hex string into an ID.
+// IDFromBytes returns the ID with the given raw bytes.
+func IDFromBytes(b []byte) (ID, error) {
+	if !ValidIDBytes(len(b)) {
+		return ID{}, fmt.Errorf("wrong length: got %d, want %d or %d", len(b), IDBytes, WIDE_ID_BYTES)
+	}
+	id := zeroID(len(b))
+	copy(id.b[:], b)
+	return id, nil
+}
+
+// ParseHexID parses a 40- or 64-char hex string into an ID.
 func ParseHexID(h string) (ID, error) {
-	var id ID
 	b, err := hex.DecodeString(h)
 	if err != nil {
-		return id, fmt.Errorf("decode hex: %w", err)
+		return ID{}, fmt.Errorf("decode hex: %w", err)
+	}
+	return IDFromBytes(b)
+}
+
+func zeroID(n int) ID {
+	if !ValidIDBytes(n) {
+		panic(fmt.Errorf("unsupported ID width %d bytes", n))
 	}
-	if len(b) != IDBytes {
-		return id, fmt.Errorf("wrong length: got %d, want %d", len(b), IDBytes)
+	return ID{wide: n == WIDE_ID_BYTES}
+}
+
+// Len returns the width of the ID in bytes.
+func (id ID) Len() int {
+	if id.wide {
+		return WIDE_ID_BYTES
 	}
-	copy(id[:], b)
-	return id, nil
+	return IDBytes
+}
+
+// Bytes returns a copy of the raw ID.
+func (id ID) Bytes() []byte {
+	return append([]byte(nil), id.b[:id.Len()]...)
 }
 
 // Hex returns the lowercase hex encoding of the ID.
-func (id ID) Hex() string { return hex.EncodeToString(id[:]) }
+func (id ID) Hex() string { return hex.EncodeToString(id.b[:id.Len()]) }
 
 // String implements fmt.Stringer (prints hex).
 func (id ID) String() string { return id.Hex() }
 
-// Less returns true if id < otherKademliaID (bitwise)
+// Less returns true if id < otherKademliaID (bitwise). Narrower IDs sort first.
 func (id ID) Less(otherKademliaID *ID) bool {
-	for i := 0; i < IDBytes; i++ {
-		if id[i] != otherKademliaID[i] {
-			return id[i] < otherKademliaID[i]
-		}
+	if id.wide != otherKademliaID.wide {
+		return !id.wide
 	}
-	return false
+	return bytes.Compare(id.b[:], otherKademliaID.b[:]) < 0
 }
 
 // Equals returns true if id == otherKademliaID (bitwise)
 func (id ID) Equals(otherKademliaID *ID) bool {
-	for i := 0; i < IDBytes; i++ {
-		if id[i] != otherKademliaID[i] {
-			return false
-		}
-	}
-	return true
+	return id == *otherKademliaID
 }
 
 // CalcDistance returns a new instance of a ID that is built
 // through a bitwise XOR operation betweeen id and target
 func (id ID) CalcDistance(target *ID) *ID {
-	result := ID{}
-	for i := 0; i < IDBytes; i++ {
-		result[i] = id[i] ^ target[i]
+	result := ID{wide: id.wide}
+	for i := 0; i < id.Len(); i++ {
+		result.b[i] = id.b[i] ^ target.b[i]
 	}
 	return &result
 }
+
+// CloserTo reports whether id is closer to target than other is, without
+// computing either distance
+func (id ID) CloserTo(target, other *ID) bool {
+	for i := 0; i < id.Len(); i++ {
+		a, b := id.b[i]^target.b[i], other.b[i]^target.b[i]
+		if a != b {
+			return a < b
+		}
+	}
+	return false
+}
+
+// CommonPrefixLen returns how many leading bits id shares with other
+// (id.Len()*8 if they are equal).
+func (id ID) CommonPrefixLen(other *ID) int {
+	for i := 0; i < id.Len(); i++ {
+		if x := id.b[i] ^ other.b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return id.Len() * 8
+}
+
+// Bit reports whether bit i, counted from the most significant, is set.
+func (id ID) Bit(i int) bool {
+	return id.b[i/8]&(0x80>>(i%8)) != 0
+}
+
+// FlipBit returns id with bit i, counted from the most significant, inverted.
+func (id ID) FlipBit(i int) ID {
+	id.b[i/8] ^= 0x80 >> (i % 8)
+	return id
+}
+
+// RandomIDWithPrefix returns a random ID as wide as prefix whose first
+// prefixLen bits are those of prefix.
+func RandomIDWithPrefix(prefix ID, prefixLen int) ID {
+	id := NewRandomIDLen(prefix.Len())
+	for i := 0; i < id.Len() && prefixLen > 0; i++ {
+		if prefixLen >= 8 {
+			id.b[i] = prefix.b[i]
+		} else {
+			mask := byte(0xff) << (8 - prefixLen)
+			id.b[i] = prefix.b[i]&mask | id.b[i]&^mask
+		}
+		prefixLen -= 8
+	}
+	return id
+}
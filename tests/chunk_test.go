@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestPutGetLargeValue stores content spanning many chunks (and a chunked
+// manifest) on A and asserts C reassembles the exact bytes.
+func TestPutGetLargeValue(t *testing.T) {
+	nodes := startNetwork(t, 27001, 3, nil)
+	a, c := nodes[0], nodes[2]
+
+	// 60 chunks need a manifest larger than one chunk, exercising depth 1
+	content := make([]byte, 60*node.CHUNK_SIZE+123)
+	rand.Read(content)
+
+	put, err := a.Put(context.Background(), content)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	key, _ := util.ParseHexID(hex.EncodeToString(put.Key))
+
+	res, err := c.Get(context.Background(), key, 800*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	got := res.Value
+	if !bytes.Equal(got, content) {
+		t.Fatalf("reassembled content mismatch: got %d bytes want %d", len(got), len(content))
+	}
+}
+
+// TestGetReturnsManifestLookalike asserts content that reads like a manifest
+// but was stored as a plain value comes back verbatim.
+func TestGetReturnsManifestLookalike(t *testing.T) {
+	nodes := startNetwork(t, 27011, 2, nil)
+	a, b := nodes[0], nodes[1]
+
+	chunk := util.HashID([]byte("chunk"), util.NewRandomID().Len())
+	content := []byte(node.MANIFEST_MAGIC + " 5 1000000\n" + chunk.String() + "\n")
+
+	put, err := a.Put(context.Background(), content)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	key, _ := util.ParseHexID(hex.EncodeToString(put.Key))
+
+	res, err := b.Get(context.Background(), key, 800*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !bytes.Equal(res.Value, content) {
+		t.Fatalf("got %q, want the stored bytes %q", res.Value, content)
+	}
+}
+
+// TestUDPServerRejectsOversizedMessage asserts a message larger than the read
+// buffer fails to send instead of being silently truncated by the receiver.
+func TestUDPServerRejectsOversizedMessage(t *testing.T) {
+	// The size check happens before the socket is touched, so no Start needed
+	s := kadnet.CreateUDPServer("127.0.0.1:0")
+
+	big := kadnet.Message{Type: kadnet.MSG_STORE, Args: []string{strings.Repeat("ab", kadnet.BUFFER_SIZE)}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := s.SendAndWait(ctx, s.Addr(), big); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("expected message too large error, got %v", err)
+	}
+}
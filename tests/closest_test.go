@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// seededContact returns contact i of a deterministic population, each at
+// its own address
+func seededContact(i int) kademlia.Contact {
+	id := util.NewIDFromSeed(fmt.Sprintf("contact-%d", i))
+	addr := &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 4000}
+	return kademlia.NewContact(&id, addr)
+}
+
+// seededTable returns a table of the given layout and bucket size after
+// adding the first n seeded contacts
+func seededTable(layout string, k, n int) kademlia.Table {
+	self := util.NewIDFromSeed("self")
+	me := kademlia.NewContact(&self, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4000})
+	params := kademlia.Params{K: k, Alpha: kademlia.ALPHA, Replication: k, IDBytes: util.IDBytes}
+	table, err := kademlia.NewTable(layout, me, params, false)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < n; i++ {
+		table.AddContact(seededContact(i))
+	}
+	return table
+}
+
+// TestFindClosestContactsIsExact compares FindClosestContacts against
+// sorting every contact in the table, with buckets only partly filled.
+func TestFindClosestContactsIsExact(t *testing.T) {
+	for _, layout := range []string{kademlia.TABLE_FLAT, kademlia.TABLE_TREE} {
+		table := seededTable(layout, 3, 2000)
+		all := table.Contacts()
+
+		for i := 0; i < 200; i++ {
+			target := util.NewIDFromSeed(fmt.Sprintf("target-%d", i))
+			want := append([]kademlia.Contact(nil), all...)
+			sort.Slice(want, func(a, b int) bool {
+				return want[a].ID.CalcDistance(&target).Less(want[b].ID.CalcDistance(&target))
+			})
+
+			got := table.FindClosestContacts(&target, 10)
+			if len(got) != 10 {
+				t.Fatalf("%s: got %d contacts, want 10", layout, len(got))
+			}
+			for j := range got {
+				if !got[j].ID.Equals(want[j].ID) {
+					t.Fatalf("%s: contact %d for target %d is %s, want %s", layout, j, i, got[j].ID, want[j].ID)
+				}
+			}
+		}
+	}
+}
+
+// TestContactIndexFollowsAddress asserts RPC outcomes are credited to a
+// contact at the address it was last seen at, and not once it is removed.
+func TestContactIndexFollowsAddress(t *testing.T) {
+	table := seededTable(kademlia.TABLE_FLAT, kademlia.K, 0)
+	c := seededContact(1)
+	table.AddContact(c)
+
+	moved := c
+	moved.Address = net.UDPAddr{IP: net.IPv4(10, 9, 9, 9), Port: 4001}
+	table.AddContact(moved)
+
+	table.RecordFailure(&c.Address)
+	table.RecordReply(&moved.Address)
+	got, ok := table.Lookup(c.ID)
+	if !ok || got.Failures != 0 || got.LastReply.IsZero() {
+		t.Fatalf("stats not recorded against the new address: %+v", got)
+	}
+
+	table.RemoveContact(moved)
+	if table.RecordFailure(&moved.Address) {
+		t.Fatalf("removed contact still found by address")
+	}
+	if _, ok := table.Lookup(c.ID); ok {
+		t.Fatalf("removed contact still found by ID")
+	}
+}
+
+// benchTable builds a table holding tens of thousands of contacts and
+// returns it with its contacts, resetting the benchmark timer
+func benchTable(b *testing.B, layout string) (kademlia.Table, []kademlia.Contact) {
+	table := seededTable(layout, 4096, 50000)
+	contacts := table.Contacts()
+	b.ReportMetric(float64(len(contacts)), "contacts")
+	b.ResetTimer()
+	return table, contacts
+}
+
+func BenchmarkFindClosestContacts(b *testing.B) {
+	for _, layout := range []string{kademlia.TABLE_FLAT, kademlia.TABLE_TREE} {
+		b.Run(layout, func(b *testing.B) {
+			targets := make([]util.ID, 1024)
+			for i := range targets {
+				targets[i] = util.NewIDFromSeed(fmt.Sprintf("target-%d", i))
+			}
+			table, _ := benchTable(b, layout)
+			for i := 0; i < b.N; i++ {
+				table.FindClosestContacts(&targets[i%len(targets)], kademlia.K)
+			}
+		})
+	}
+}
+
+func BenchmarkAddKnownContact(b *testing.B) {
+	for _, layout := range []string{kademlia.TABLE_FLAT, kademlia.TABLE_TREE} {
+		b.Run(layout, func(b *testing.B) {
+			table, contacts := benchTable(b, layout)
+			for i := 0; i < b.N; i++ {
+				table.AddContact(contacts[i%len(contacts)])
+			}
+		})
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	for _, layout := range []string{kademlia.TABLE_FLAT, kademlia.TABLE_TREE} {
+		b.Run(layout, func(b *testing.B) {
+			table, contacts := benchTable(b, layout)
+			for i := 0; i < b.N; i++ {
+				table.Lookup(contacts[i%len(contacts)].ID)
+			}
+		})
+	}
+}
+
+func BenchmarkRecordReply(b *testing.B) {
+	for _, layout := range []string{kademlia.TABLE_FLAT, kademlia.TABLE_TREE} {
+		b.Run(layout, func(b *testing.B) {
+			table, contacts := benchTable(b, layout)
+			for i := 0; i < b.N; i++ {
+				table.RecordReply(&contacts[i%len(contacts)].Address)
+			}
+		})
+	}
+}
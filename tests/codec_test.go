@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestBinaryCodecRoundTrip asserts every kind of argument survives the binary
+// encoding unchanged and that hex-heavy messages shrink on the wire.
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	id := util.NewRandomID()
+	value := hex.EncodeToString(make([]byte, 1024))
+	msgs := []kadnet.Message{
+		{Type: kadnet.MSG_PING, RPCID: util.NewRandomID().Hex(), Args: []string{id.String()}},
+		{Type: kadnet.MSG_STORE, RPCID: "b1." + id.Hex(), Args: []string{id.String(), id.String(), value, "3600"}},
+		{Type: kadnet.MSG_NODES, Args: []string{
+			id.String(),
+			id.String() + "@127.0.0.1:6882",
+			id.String() + "@[::1]:6882",
+			id.String() + "@bootstrap:6882",
+		}},
+		{Type: "CUSTOM", Args: []string{"has spaces in it", "", "ABCDEF", "abc"}},
+	}
+
+	for _, m := range msgs {
+		enc := kadnet.EncodeBinary(m)
+		got, format, err := kadnet.DecodePacket(enc)
+		if err != nil {
+			t.Fatalf("decode %s: %v", m.Type, err)
+		}
+		if format != kadnet.FORMAT_BINARY {
+			t.Fatalf("decode %s: detected %s format", m.Type, format)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Fatalf("round trip mismatch:\n got %#v\nwant %#v", got, m)
+		}
+	}
+
+	store := msgs[1]
+	if bin, txt := len(kadnet.EncodeBinary(store)), len(store.String()); bin*2 > txt+100 {
+		t.Fatalf("binary STORE not compact: %d bytes vs %d text", bin, txt)
+	}
+
+	if _, err := kadnet.DecodeBinary([]byte{kadnet.BINARY_MAGIC, 99, 1}); err == nil {
+		t.Fatalf("expected unknown version to be rejected")
+	}
+	if _, err := kadnet.DecodeBinary(kadnet.EncodeBinary(store)[:40]); err == nil {
+		t.Fatalf("expected truncated packet to be rejected")
+	}
+}
+
+// TestBinaryNamesNewerTypes asserts message types added after binary
+// version 1 are sent by name, which every v1 peer can decode.
+func TestBinaryNamesNewerTypes(t *testing.T) {
+	for _, typ := range []string{kadnet.MSG_FIND_VALUE, kadnet.MSG_STORE_REFUSED, kadnet.MSG_PUT_MUTABLE} {
+		if enc := kadnet.EncodeBinary(kadnet.Message{Type: typ}); enc[1] != 1 || enc[2] != 0 {
+			t.Fatalf("%s encoded as version %d code %d, want version 1 by name", typ, enc[1], enc[2])
+		}
+	}
+}
+
+// TestWireFormatNegotiation runs two binary-capable servers and one text-only
+// server over real UDP. An argument containing a space only survives binary,
+// so it shows which format each pair ended up using.
+func TestWireFormatNegotiation(t *testing.T) {
+	start := func(addr, format string) *kadnet.UDPServer {
+		s := kadnet.CreateUDPServer(addr)
+		if err := s.SetWireFormat(format); err != nil {
+			t.Fatalf("SetWireFormat: %v", err)
+		}
+		s.On("ECHO", func(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+			return &kadnet.Message{Type: "ECHOED", RPCID: msg.RPCID, Args: []string{strconv.Itoa(len(msg.Args))}}, nil
+		})
+		go s.Start()
+		return s
+	}
+	newA := start("127.0.0.1:28001", kadnet.FORMAT_BINARY)
+	defer newA.Close()
+	newB := start("127.0.0.1:28002", kadnet.FORMAT_BINARY)
+	defer newB.Close()
+	old := start("127.0.0.1:28003", kadnet.FORMAT_TEXT)
+	defer old.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	echo := func(from, to *kadnet.UDPServer) string {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		reply, err := from.SendAndWait(ctx, to.Addr(), kadnet.Message{Type: "ECHO", Args: []string{"two words"}})
+		if err != nil {
+			t.Fatalf("ECHO %s -> %s failed: %v", from.Addr(), to.Addr(), err)
+		}
+		return reply.Args[0]
+	}
+
+	// First contact is text with a binary advert, so the argument is split
+	if got := echo(newA, newB); got != "2" {
+		t.Fatalf("first request should be text, handler saw %s args", got)
+	}
+	// newB replied in binary, so newA now sends binary
+	if got := echo(newA, newB); got != "1" {
+		t.Fatalf("second request should be binary, handler saw %s args", got)
+	}
+	// The text-only node keeps working and never receives binary
+	for i := 0; i < 2; i++ {
+		if got := echo(newA, old); got != "2" {
+			t.Fatalf("text-only peer should receive text, handler saw %s args", got)
+		}
+		if got := echo(old, newA); got != "2" {
+			t.Fatalf("text-only sender should get text handling, handler saw %s args", got)
+		}
+	}
+}
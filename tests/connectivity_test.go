@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"net"
 	"testing"
 	"time"
@@ -27,7 +28,7 @@ func TestPingPongWithMock(t *testing.T) {
 
 	peer, _ := net.ResolveUDPAddr("udp", "127.0.0.1:10001")
 	t.Logf("Resolved peer address: %s", peer.String())
-	id, err := b.PingSync(peer, 500*time.Millisecond)
+	id, err := b.PingSync(context.Background(), peer, 500*time.Millisecond)
 	t.Logf("PingSync returned id=%s, err=%v", id.String(), err)
 	if err != nil {
 		t.Fatalf("ping failed: %v", err)
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestCancelAbortsLookup asserts cancelling the context ends a lookup stuck
+// on a peer at once, and that the abandoned RPC is not held against the peer.
+func TestCancelAbortsLookup(t *testing.T) {
+	hung := startHung(t, node.NodeConfig{Addr: "127.0.0.1:45001"})
+	searcher := startNode(t, node.NodeConfig{Addr: "127.0.0.1:45002"})
+	introduce(searcher, hung)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := searcher.FindValue(ctx, util.NewRandomID(), 10*time.Second)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("lookup took %v after cancel", elapsed)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if c, ok := searcher.RoutingTable.Lookup(&hung.ID); !ok || c.Failures != 0 {
+		t.Fatalf("cancelled RPC counted against the peer: %+v (known=%v)", c, ok)
+	}
+}
+
+// TestShutdownAbortsLookup asserts shutting the node down ends a lookup it
+// has in flight.
+func TestShutdownAbortsLookup(t *testing.T) {
+	hung := startHung(t, node.NodeConfig{Addr: "127.0.0.1:45003"})
+	searcher := startNode(t, node.NodeConfig{Addr: "127.0.0.1:45004"})
+	introduce(searcher, hung)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := searcher.FindValue(context.Background(), util.NewRandomID(), 10*time.Second)
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := searcher.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("want context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("lookup still running after shutdown")
+	}
+}
+
+// TestOperationTimeoutBoundsLookup asserts an operation whose context has no
+// deadline still ends after Config.OperationTimeout.
+func TestOperationTimeoutBoundsLookup(t *testing.T) {
+	hung := startHung(t, node.NodeConfig{Addr: "127.0.0.1:45005"})
+	searcher := startNode(t, node.NodeConfig{Addr: "127.0.0.1:45006", OperationTimeout: 200 * time.Millisecond})
+	introduce(searcher, hung)
+
+	start := time.Now()
+	_, err := searcher.Put(context.Background(), []byte("never stored"))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("put took %v with a 200ms operation timeout", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestEvictionPingDoesNotBlockHandlers fills a bucket with members of a
+// peer that is slow to fail and asserts FIND_NODE from newcomers returns at
+// once, the stale member is pinged only once, and a newcomer takes its slot.
+func TestEvictionPingDoesNotBlockHandlers(t *testing.T) {
+	// A zero first bit puts every farContact in one bucket
+	n := startNode(t, node.NodeConfig{ID: util.RandomIDWithPrefix(util.ID{}, 1), Addr: "127.0.0.1:37001"})
+
+	// A peer whose pings hang before failing, like a host that went away
+	var pings int32
+	dead := kadnet.NewMockUDP("127.0.0.1:37002")
+	defer dead.Close()
+	dead.On(kadnet.MSG_PING, func(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+		atomic.AddInt32(&pings, 1)
+		time.Sleep(300 * time.Millisecond)
+		return nil, nil
+	})
+
+	var members []kademlia.Contact
+	for i := 0; i < kademlia.K; i++ {
+		c := farContact(i)
+		c.Address = *dead.Addr()
+		n.AddContact(c)
+		members = append(members, c)
+	}
+
+	var newcomers []util.ID
+	from, _ := net.ResolveUDPAddr("udp", "127.0.0.1:37003")
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		id := farContact(100 + i).ID
+		msg := kadnet.Message{Type: kadnet.MSG_FIND_NODE, Args: []string{id.String(), util.NewRandomID().String()}}
+		if _, err := n.HandleFindNode(from, msg); err != nil {
+			t.Fatalf("HandleFindNode failed: %v", err)
+		}
+		newcomers = append(newcomers, *id)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("handlers blocked on eviction pings for %v", elapsed)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !hasContact(n.RoutingTable.Contacts(), newcomers[2]) {
+		if time.Now().After(deadline) {
+			t.Fatalf("newest newcomer never replaced the dead member")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if hasContact(n.RoutingTable.Contacts(), *members[0].ID) {
+		t.Fatalf("dead member still in the routing table")
+	}
+	if got := atomic.LoadInt32(&pings); got != 1 {
+		t.Fatalf("dead member pinged %d times, want 1", got)
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestFindValueStopsAtFirstHit stores a value on the peer closest to its key
+// and asserts the lookup finds it in the first round: no FIND_NODE pass, and
+// peers outside the first ALPHA never hear about the key.
+func TestFindValueStopsAtFirstHit(t *testing.T) {
+	nodes := startNetwork(t, 34001, 7, func(i int, cfg *node.NodeConfig) {
+		cfg.DisablePathCache = i == 6
+	})
+	peers, requester := nodes[:6], nodes[6]
+
+	value := []byte("Found on the first hop")
+	sum := sha1.Sum(value)
+	key, _ := util.ParseHexID(hex.EncodeToString(sum[:]))
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID.CalcDistance(&key).Less(peers[j].ID.CalcDistance(&key))
+	})
+	store := kadnet.Message{Type: kadnet.MSG_STORE, Args: []string{peers[0].ID.String(), key.String(), hex.EncodeToString(value)}}
+	if _, err := peers[0].HandleStore(nil, store); err != nil {
+		t.Fatalf("HandleStore failed: %v", err)
+	}
+
+	// The other first-round peers answer later, so the hit ends the lookup
+	// before their misses could start queries further out
+	for _, p := range peers[1:3] {
+		p.Server.(*kadnet.MockUDP).SetLatency(50 * time.Millisecond)
+	}
+
+	var findNodes atomic.Int32
+	findValues := make([]atomic.Int32, len(peers))
+	for i, p := range peers {
+		p.Server.On(kadnet.MSG_FIND_NODE, func(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+			findNodes.Add(1)
+			return p.HandleFindNode(from, msg)
+		})
+		p.Server.On(kadnet.MSG_FIND_VALUE, func(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+			findValues[i].Add(1)
+			return p.HandleFindValue(from, msg)
+		})
+	}
+
+	res, err := requester.FindValue(context.Background(), key, 800*time.Millisecond)
+	if err != nil {
+		t.Fatalf("FindValue failed: %v", err)
+	}
+	if string(res.Value) != string(value) || !res.From.ID.Equals(&peers[0].ID) {
+		t.Fatalf("got %q from %v, want %q from %s", res.Value, res.From, value, peers[0].Addr)
+	}
+	if got := findNodes.Load(); got != 0 {
+		t.Fatalf("value lookup sent %d FIND_NODE request(s)", got)
+	}
+	for i := 3; i < len(peers); i++ {
+		if got := findValues[i].Load(); got != 0 {
+			t.Fatalf("peer %d beyond the first round got %d FIND_VALUE request(s)", i, got)
+		}
+	}
+
+	// GET keeps working for nodes that do not send FIND_VALUE yet
+	get := kadnet.Message{Type: kadnet.MSG_GET, Args: []string{requester.ID.String(), key.String()}}
+	if reply, _ := peers[0].HandleGet(nil, get); reply.Type != kadnet.MSG_VALUE {
+		t.Fatalf("GET should still serve the value, got %s", reply.Type)
+	}
+}
+
+// TestFindValueServesLocalCopy asserts a value held locally is returned
+// without a lookup, reported as local.
+func TestFindValueServesLocalCopy(t *testing.T) {
+	nodes := startNetwork(t, 34021, 2, nil)
+	a, b := nodes[0], nodes[1]
+
+	value := []byte("Already here")
+	sum := sha1.Sum(value)
+	key, _ := util.ParseHexID(hex.EncodeToString(sum[:]))
+	store := kadnet.Message{Type: kadnet.MSG_STORE, Args: []string{a.ID.String(), key.String(), hex.EncodeToString(value)}}
+	if _, err := b.HandleStore(nil, store); err != nil {
+		t.Fatalf("HandleStore failed: %v", err)
+	}
+
+	// A would answer too, but never hears about the key
+	var asked atomic.Int32
+	a.Server.On(kadnet.MSG_FIND_VALUE, func(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+		asked.Add(1)
+		return a.HandleFindValue(from, msg)
+	})
+	res, err := b.FindValue(context.Background(), key, 800*time.Millisecond)
+	if err != nil || string(res.Value) != string(value) {
+		t.Fatalf("FindValue failed: %v %q", err, res.Value)
+	}
+	if res.From != nil || asked.Load() != 0 {
+		t.Fatalf("local value should be served without a lookup, from %v after %d queries", res.From, asked.Load())
+	}
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// JOIN_WAIT is how long the helpers wait for a started node to join
+const JOIN_WAIT = 5 * time.Second
+
+// mockNet gives a test node a MockUDP transport
+func mockNet(addr string) kadnet.Network { return kadnet.NewMockUDP(addr) }
+
+// newNode creates a node from cfg on the mock network without waiting for
+// it to join. A zero ID is replaced by a random one. The node is shut down
+// when the test ends.
+func newNode(t *testing.T, cfg node.NodeConfig) *node.Node {
+	t.Helper()
+	if cfg.ID == (util.ID{}) {
+		cfg.ID = util.NewRandomID()
+	}
+	if cfg.NewNet == nil {
+		cfg.NewNet = mockNet
+	}
+	n := node.CreateNode(cfg)
+	t.Cleanup(func() { n.Shutdown(context.Background()) })
+	return n
+}
+
+// startNode is newNode followed by waiting for the node to join
+func startNode(t *testing.T, cfg node.NodeConfig) *node.Node {
+	t.Helper()
+	n := newNode(t, cfg)
+	waitReady(t, JOIN_WAIT, n)
+	return n
+}
+
+// startNetwork starts size nodes on consecutive ports from base, the first
+// bootstrapping and the rest joining through it one after another. tweak,
+// if set, adjusts the config of the i-th node before it starts.
+func startNetwork(t *testing.T, base, size int, tweak func(i int, cfg *node.NodeConfig)) []*node.Node {
+	t.Helper()
+	var nodes []*node.Node
+	for i := 0; i < size; i++ {
+		cfg := node.NodeConfig{Addr: fmt.Sprintf("127.0.0.1:%d", base+i)}
+		if i > 0 {
+			cfg.Peers = []string{nodes[0].Addr}
+		}
+		if tweak != nil {
+			tweak(i, &cfg)
+		}
+		nodes = append(nodes, startNode(t, cfg))
+	}
+	return nodes
+}
+
+// startHung is startNode for a node that never answers once started
+func startHung(t *testing.T, cfg node.NodeConfig) *node.Node {
+	t.Helper()
+	n := startNode(t, cfg)
+	n.Server.(*kadnet.MockUDP).SetLatency(time.Hour)
+	return n
+}
+
+// introduce adds each of others to n's routing table without a join
+func introduce(n *node.Node, others ...*node.Node) {
+	for _, o := range others {
+		addr, _ := net.ResolveUDPAddr("udp", o.Addr)
+		n.AddContact(kademlia.NewContact(&o.ID, addr))
+	}
+}
+
+// waitReady fails the test unless every node joins within timeout
+func waitReady(t *testing.T, timeout time.Duration, nodes ...*node.Node) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for _, n := range nodes {
+		select {
+		case <-n.Ready():
+		case <-deadline:
+			t.Fatalf("node %s not ready after %v", n.Addr, timeout)
+		}
+	}
+}
+
+// hasContact reports whether id is among contacts
+func hasContact(contacts []kademlia.Contact, id util.ID) bool {
+	for _, c := range contacts {
+		if c.ID.Equals(&id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+)
+
+// quickRetry configures a node joining through peers that retries quickly
+func quickRetry(addr string, peers ...string) node.NodeConfig {
+	return node.NodeConfig{
+		Addr: addr, Peers: peers, JoinRetryMin: 50 * time.Millisecond, JoinRetryMax: 200 * time.Millisecond,
+	}
+}
+
+// TestJoinRetriesUntilPeerIsUp asserts a node whose bootstrap peer is not
+// up yet keeps trying and joins once it is, as under docker-compose.
+func TestJoinRetriesUntilPeerIsUp(t *testing.T) {
+	b := newNode(t, quickRetry("127.0.0.1:46002", "127.0.0.1:46001"))
+	select {
+	case <-b.Ready():
+		t.Fatal("ready without any peer up")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	a := startNode(t, quickRetry("127.0.0.1:46001"))
+	waitReady(t, 2*time.Second, a, b)
+	if !hasContact(b.RoutingTable.Contacts(), a.ID) {
+		t.Fatal("joined without adding the bootstrap peer")
+	}
+}
+
+// TestJoinResolvesHostNames asserts peers may be given by host name
+func TestJoinResolvesHostNames(t *testing.T) {
+	a := startNode(t, quickRetry("127.0.0.1:46011"))
+	b := startNode(t, quickRetry("127.0.0.1:46012", "localhost:46011"))
+
+	if !hasContact(b.RoutingTable.Contacts(), a.ID) || !hasContact(a.RoutingTable.Contacts(), b.ID) {
+		t.Fatal("peer named by host name not joined")
+	}
+}
+
+// TestRejoinWhenTableEmpties asserts a node that lost every contact joins
+// again through its peers once one of them is back.
+func TestRejoinWhenTableEmpties(t *testing.T) {
+	a := startNode(t, quickRetry("127.0.0.1:46021"))
+	b := startNode(t, quickRetry("127.0.0.1:46022", a.Addr))
+
+	// A goes away and B gives up on it
+	a.Shutdown(context.Background())
+	addr, _ := net.ResolveUDPAddr("udp", a.Addr)
+	for hasContact(b.RoutingTable.Contacts(), a.ID) {
+		b.PingSync(context.Background(), addr, 50*time.Millisecond)
+	}
+
+	restarted := startNode(t, quickRetry(a.Addr))
+	deadline := time.Now().Add(2 * time.Second)
+	for !hasContact(b.RoutingTable.Contacts(), restarted.ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("did not rejoin after the routing table emptied")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
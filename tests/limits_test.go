@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/storage"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestLimitedStoreQuotasAndLRU checks each limit of a LimitedStore and that
+// LRU eviction spares a recently read record.
+func TestLimitedStoreQuotasAndLRU(t *testing.T) {
+	s, err := storage.NewLimitedStore(storage.NewMemoryStore(), storage.Limits{
+		MaxValueSize: 10, MaxBytes: 30, MaxPublisherBytes: 20,
+	}, storage.EvictLRU)
+	if err != nil {
+		t.Fatalf("NewLimitedStore failed: %v", err)
+	}
+	rec := func(pub string) storage.Record {
+		return storage.Record{Value: []byte("0123456789"), ExpiresAt: time.Now().Add(time.Hour), Publisher: pub}
+	}
+
+	if err := s.Put("big", storage.Record{Value: make([]byte, 11)}); !errors.Is(err, storage.ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+
+	for _, k := range []string{"a", "b"} {
+		if err := s.Put(k, rec("p1")); err != nil {
+			t.Fatalf("Put %s failed: %v", k, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := s.Put("c", rec("p1")); !errors.Is(err, storage.ErrPublisherQuota) {
+		t.Fatalf("expected ErrPublisherQuota, got %v", err)
+	}
+	// Replacing a key does not count its old bytes twice
+	if err := s.Put("a", rec("p1")); err != nil {
+		t.Fatalf("overwrite within quota failed: %v", err)
+	}
+
+	if err := s.Put("c", rec("p2")); err != nil {
+		t.Fatalf("Put c failed: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	s.Get("b")
+
+	// Full: the least recently used record (a) makes room for d
+	if err := s.Put("d", rec("p2")); err != nil {
+		t.Fatalf("Put d failed: %v", err)
+	}
+	if _, ok, _ := s.Get("a"); ok {
+		t.Fatalf("a should have been evicted")
+	}
+	for _, k := range []string{"b", "c", "d"} {
+		if _, ok, _ := s.Get(k); !ok {
+			t.Fatalf("%s should have been kept", k)
+		}
+	}
+	if got := s.PublisherBytes("p1"); got != 10 {
+		t.Fatalf("p1 should hold 10 bytes after eviction, got %d", got)
+	}
+}
+
+// TestLimitedStoreKeepsOwnValues asserts records without a publisher are
+// never evicted, even when they are the least recently used.
+func TestLimitedStoreKeepsOwnValues(t *testing.T) {
+	s, err := storage.NewLimitedStore(storage.NewMemoryStore(), storage.Limits{MaxBytes: 20}, storage.EvictLRU)
+	if err != nil {
+		t.Fatalf("NewLimitedStore failed: %v", err)
+	}
+	rec := func(pub string) storage.Record {
+		return storage.Record{Value: []byte("0123456789"), ExpiresAt: time.Now().Add(time.Hour), Publisher: pub}
+	}
+
+	for _, put := range []struct{ key, pub string }{{"mine", ""}, {"a", "p1"}, {"b", "p2"}} {
+		if err := s.Put(put.key, rec(put.pub)); err != nil {
+			t.Fatalf("Put %s failed: %v", put.key, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok, _ := s.Get("mine"); !ok {
+		t.Fatal("own value was evicted")
+	}
+	if _, ok, _ := s.Get("a"); ok {
+		t.Fatal("a should have made room for b")
+	}
+
+	// With only own values left to evict, the store is full
+	if err := s.Put("mine2", rec("")); err != nil {
+		t.Fatalf("Put mine2 failed: %v", err)
+	}
+	if err := s.Put("mine3", rec("")); !errors.Is(err, storage.ErrStoreFull) {
+		t.Fatalf("expected ErrStoreFull, got %v", err)
+	}
+}
+
+// TestFileStoreKeepsPublisher asserts the publisher survives a reopen.
+func TestFileStoreKeepsPublisher(t *testing.T) {
+	dir := t.TempDir()
+	s, err := storage.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	pub := util.NewRandomID().String()
+	s.Put("from", storage.Record{Value: []byte("x"), ExpiresAt: time.Now().Add(time.Hour), Publisher: pub})
+	s.Put("own", storage.Record{Value: []byte("y"), ExpiresAt: time.Now().Add(time.Hour)})
+	s.Close()
+
+	s, err = storage.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer s.Close()
+	if rec, _, _ := s.Get("from"); rec.Publisher != pub || string(rec.Value) != "x" {
+		t.Fatalf("got publisher %q value %q, want %q %q", rec.Publisher, rec.Value, pub, "x")
+	}
+	if rec, _, _ := s.Get("own"); rec.Publisher != "" || string(rec.Value) != "y" {
+		t.Fatalf("got publisher %q value %q, want none %q", rec.Publisher, rec.Value, "y")
+	}
+}
+
+// TestStoreRefusals fills a node that evicts keys furthest from its own ID and
+// asserts refusals reach the sender as STORE_REFUSED.
+func TestStoreRefusals(t *testing.T) {
+	// A zero first byte, so keys with a high first byte are far
+	self := util.RandomIDWithPrefix(util.ID{}, 8)
+	full := startNode(t, node.NodeConfig{
+		ID: self, Addr: "127.0.0.1:32001",
+		MaxValueSize: 8, MaxStoreBytes: 16, MaxPublisherBytes: 16,
+	})
+	sender := startNode(t, node.NodeConfig{Addr: "127.0.0.1:32002", Peers: []string{full.Addr}})
+
+	key := func(first byte) string {
+		raw := make([]byte, util.IDBytes)
+		raw[0] = first
+		id, _ := util.IDFromBytes(raw)
+		return id.String()
+	}
+	addr, _ := net.ResolveUDPAddr("udp", full.Addr)
+	to := kademlia.NewContact(&full.ID, addr)
+	store := func(k string, v string) error {
+		return sender.SendStoreSync(context.Background(), to, k, []byte(v), 0, 800*time.Millisecond)
+	}
+	expectRefused := func(err error, reason string) {
+		t.Helper()
+		if !errors.Is(err, node.ErrStoreRefused) || !strings.HasSuffix(err.Error(), reason) {
+			t.Fatalf("expected refusal %q, got %v", reason, err)
+		}
+	}
+
+	expectRefused(store(key(0x01), "too large"), node.REFUSE_TOO_LARGE)
+
+	if err := store(key(0x80), "far-away"); err != nil {
+		t.Fatalf("store far key failed: %v", err)
+	}
+	if err := store(key(0x02), "near-one"); err != nil {
+		t.Fatalf("store near key failed: %v", err)
+	}
+	// Over the publisher quota before the store is considered full
+	expectRefused(store(key(0x03), "near-two"), node.REFUSE_QUOTA)
+
+	// A second publisher's near key evicts the far one...
+	other := startNode(t, node.NodeConfig{Addr: "127.0.0.1:32003", Peers: []string{full.Addr}})
+	if err := other.SendStoreSync(context.Background(), to, key(0x03), []byte("near-two"), 0, 800*time.Millisecond); err != nil {
+		t.Fatalf("store near key from second publisher failed: %v", err)
+	}
+	get := func(k string) string {
+		reply, _ := full.HandleGet(nil, kadnet.Message{Type: kadnet.MSG_GET, Args: []string{sender.ID.String(), k}})
+		return reply.Type
+	}
+	if got := get(key(0x80)); got != kadnet.MSG_NOT_FOUND {
+		t.Fatalf("far key should have been evicted, got %s", got)
+	}
+	// ...but a key further than everything held is refused instead
+	err := other.SendStoreSync(context.Background(), to, key(0xff), []byte("furthest"), 0, 800*time.Millisecond)
+	expectRefused(err, node.REFUSE_FULL)
+	if got := get(key(0x02)); got != kadnet.MSG_VALUE {
+		t.Fatalf("near key should have been kept, got %s", got)
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestLivenessStats asserts replies set the last reply and clear failures, and that
+// MAX_FAILURES in a row drop a contact in favour of a replacement.
+func TestLivenessStats(t *testing.T) {
+	var self util.ID
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:38000")
+	rt := kademlia.CreateRoutingTable(kademlia.NewContact(&self, addr))
+
+	var members []kademlia.Contact
+	for i := 1; i <= kademlia.K; i++ {
+		c := farContact(i)
+		rt.AddContact(c)
+		members = append(members, c)
+	}
+	spare := farContact(100)
+	rt.AddContact(spare)
+
+	flaky := members[5]
+	rt.RecordFailure(&flaky.Address)
+	rt.RecordReply(&flaky.Address)
+	got, _ := rt.Lookup(flaky.ID)
+	if got.Failures != 0 || got.LastReply.IsZero() {
+		t.Fatalf("reply should clear failures and set last reply: %+v", got)
+	}
+
+	dead := members[7]
+	for i := 1; i < kademlia.MAX_FAILURES; i++ {
+		if rt.RecordFailure(&dead.Address) {
+			t.Fatalf("contact dropped after only %d failures", i)
+		}
+	}
+	if got, _ := rt.Lookup(dead.ID); got.Failures != kademlia.MAX_FAILURES-1 {
+		t.Fatalf("failures not counted")
+	}
+	if !rt.RecordFailure(&dead.Address) {
+		t.Fatalf("contact not dropped after %d failures", kademlia.MAX_FAILURES)
+	}
+	if hasContact(rt.Contacts(), *dead.ID) || !hasContact(rt.Contacts(), *spare.ID) {
+		t.Fatalf("dead contact should be replaced by the cached candidate")
+	}
+}
+
+// TestClosestPrefersFreshContacts asserts a contact that failed its last RPC
+// is only returned when not enough fresh contacts are known.
+func TestClosestPrefersFreshContacts(t *testing.T) {
+	var self util.ID
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:38000")
+	rt := kademlia.CreateRoutingTable(kademlia.NewContact(&self, addr))
+	for i := 1; i <= 10; i++ {
+		rt.AddContact(farContact(i))
+	}
+
+	target := *farContact(0).ID
+	closest := rt.FindClosestContacts(&target, 3)
+	stale := closest[0]
+	rt.RecordFailure(&stale.Address)
+
+	got := rt.FindClosestContacts(&target, 3)
+	if len(got) != 3 || hasContact(got, *stale.ID) {
+		t.Fatalf("stale contact returned while fresh ones were available")
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].Less(&got[i-1]) {
+			t.Fatalf("result not sorted by distance")
+		}
+	}
+	if got := rt.FindClosestContacts(&target, 10); !hasContact(got, *stale.ID) {
+		t.Fatalf("stale contact should fill in when fresh ones run out")
+	}
+}
+
+// TestUnresponsiveContactDropped asserts a contact that keeps timing out
+// during lookups is removed from the routing table.
+func TestUnresponsiveContactDropped(t *testing.T) {
+	nodes := startNetwork(t, 38001, 2, nil)
+	a, b := nodes[0], nodes[1]
+
+	// Nothing listens at the ghost's address
+	ghostID := util.NewRandomID()
+	ghostAddr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:38003")
+	ghost := kademlia.NewContact(&ghostID, ghostAddr)
+	b.AddContact(ghost)
+
+	for i := 0; i < kademlia.MAX_FAILURES; i++ {
+		if !hasContact(b.RoutingTable.Contacts(), ghostID) {
+			t.Fatalf("ghost dropped after only %d lookups", i)
+		}
+		b.IterativeFindNode(context.Background(), util.NewRandomID(), 200*time.Millisecond)
+	}
+	if hasContact(b.RoutingTable.Contacts(), ghostID) {
+		t.Fatalf("ghost still in the routing table after %d failed RPCs", kademlia.MAX_FAILURES)
+	}
+	if !hasContact(b.RoutingTable.Contacts(), a.ID) {
+		t.Fatalf("responsive contact should stay")
+	}
+}
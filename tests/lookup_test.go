@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// nearTarget gives a node an ID sharing target's first bit and a
+// replication factor of 3
+func nearTarget(target util.ID) func(int, *node.NodeConfig) {
+	return func(_ int, cfg *node.NodeConfig) {
+		cfg.ID = util.RandomIDWithPrefix(target, 1)
+		cfg.Replication = 3
+	}
+}
+
+// TestLookupDoesNotWaitForHungNode asserts a node that never answers only
+// holds up its own query: the lookup finishes once the closest nodes have
+// replied, long before the hung query times out.
+func TestLookupDoesNotWaitForHungNode(t *testing.T) {
+	target := util.NewIDFromSeed("lookup-target")
+
+	// Far from the target, so it never ranks among the closest found
+	hung := startHung(t, node.NodeConfig{ID: target.FlipBit(0), Addr: "127.0.0.1:44010", Replication: 3})
+	live := startNetwork(t, 44001, 6, nearTarget(target))
+	searcher := startNode(t, node.NodeConfig{Addr: "127.0.0.1:44020", Replication: 3})
+	introduce(searcher, live[0], hung)
+
+	const timeout = 2 * time.Second
+	start := time.Now()
+	res := searcher.FindNode(context.Background(), target, timeout)
+	if elapsed := time.Since(start); elapsed > timeout/2 {
+		t.Fatalf("lookup took %v, waiting on the hung node", elapsed)
+	}
+	if len(res.Contacts) != 3 || hasContact(res.Contacts, hung.ID) {
+		t.Fatalf("want the 3 closest live nodes, got %v", res.Contacts)
+	}
+}
+
+// TestLookupDropsFailedContacts asserts a contact whose query fails is not
+// returned, even when it is the closest one known.
+func TestLookupDropsFailedContacts(t *testing.T) {
+	target := util.NewIDFromSeed("lookup-target")
+
+	// Nothing listens at the ghost's address
+	ghostID := util.RandomIDWithPrefix(target, 40)
+	ghostAddr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:44030")
+	live := startNetwork(t, 44001, 6, nearTarget(target))
+	searcher := startNode(t, node.NodeConfig{Addr: "127.0.0.1:44021", Replication: 3})
+	introduce(searcher, live[0])
+	searcher.AddContact(kademlia.NewContact(&ghostID, ghostAddr))
+
+	res := searcher.FindNode(context.Background(), target, time.Second)
+	if hasContact(res.Contacts, ghostID) {
+		t.Fatalf("failed contact kept in the result: %v", res.Contacts)
+	}
+	if len(res.Contacts) != 3 {
+		t.Fatalf("want the 3 closest live nodes, got %v", res.Contacts)
+	}
+}
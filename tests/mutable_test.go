@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestMutableRecordUpdates publishes two versions of a record from A and
+// asserts C always reads the newest one, with an increasing seq.
+func TestMutableRecordUpdates(t *testing.T) {
+	nodes := startNetwork(t, 31001, 3, nil)
+	a, c := nodes[0], nodes[2]
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+
+	for i, want := range []string{"first version", "second version"} {
+		rec, err := a.PutMutable(context.Background(), priv, []byte(want))
+		if err != nil {
+			t.Fatalf("PutMutable failed: %v", err)
+		}
+		if rec.Seq != uint64(i+1) {
+			t.Fatalf("put %d got seq %d", i, rec.Seq)
+		}
+
+		res, err := c.GetMutable(context.Background(), pub, 800*time.Millisecond)
+		if err != nil {
+			t.Fatalf("GetMutable failed: %v", err)
+		}
+		if string(res.Record.Value) != want || res.Record.Seq != rec.Seq {
+			t.Fatalf("got %q seq %d, want %q seq %d", res.Record.Value, res.Record.Seq, want, rec.Seq)
+		}
+	}
+}
+
+// TestPutMutableNeedsAnswers asserts a PutMutable that cannot learn the
+// current seq fails, and leaves no record behind locally.
+func TestPutMutableNeedsAnswers(t *testing.T) {
+	nodes := startNetwork(t, 31021, 2, nil)
+	a, b := nodes[0], nodes[1]
+
+	// A stops answering in time, so B cannot tell whether a record exists
+	a.Server.(*kadnet.MockUDP).SetLatency(time.Hour)
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := b.PutMutable(context.Background(), priv, []byte("blind write")); err == nil {
+		t.Fatal("PutMutable succeeded without any node answering")
+	}
+
+	target := node.MutableTarget(pub, b.ID.Len())
+	get := kadnet.Message{Type: kadnet.MSG_GET_MUTABLE, Args: []string{a.ID.String(), target.String()}}
+	if reply, _ := b.HandleGetMutable(nil, get); reply.Type != kadnet.MSG_NOT_FOUND {
+		t.Fatalf("failed put left a local record: %s", reply.Type)
+	}
+}
+
+// TestMutableRecordValidation asserts a storing node refuses forged records
+// and records older than the one it holds, saying why in STORE_REFUSED.
+func TestMutableRecordValidation(t *testing.T) {
+	n := startNode(t, node.NodeConfig{Addr: "127.0.0.1:31011"})
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	// put returns "" when the record was stored, else the refusal reason
+	put := func(rec node.MutableRecord) string {
+		t.Helper()
+		msg := kadnet.Message{Type: kadnet.MSG_PUT_MUTABLE, Args: []string{
+			n.ID.String(),
+			hex.EncodeToString(rec.PublicKey),
+			strconv.FormatUint(rec.Seq, 10),
+			hex.EncodeToString(rec.Value),
+			hex.EncodeToString(rec.Signature),
+		}}
+		reply, err := n.HandlePutMutable(nil, msg)
+		if err != nil {
+			t.Fatalf("HandlePutMutable failed: %v", err)
+		}
+		if reply.Type == kadnet.MSG_STORE_REFUSED {
+			return reply.Args[2]
+		}
+		return ""
+	}
+
+	if reason := put(node.SignMutable(priv, 5, []byte("current"))); reason != "" {
+		t.Fatalf("valid record refused: %s", reason)
+	}
+	if reason := put(node.SignMutable(priv, 4, []byte("older"))); reason != node.REFUSE_STALE_SEQ {
+		t.Fatalf("older seq should be refused as stale, got %q", reason)
+	}
+	forged := node.SignMutable(priv, 6, []byte("signed"))
+	forged.Value = []byte("tampered")
+	if reason := put(forged); reason != node.REFUSE_BAD_SIGNATURE {
+		t.Fatalf("tampered record should be refused for its signature, got %q", reason)
+	}
+
+	get := kadnet.Message{Type: kadnet.MSG_GET_MUTABLE, Args: []string{n.ID.String(), node.MutableTarget(priv.Public().(ed25519.PublicKey), util.IDBytes).String()}}
+	reply, err := n.HandleGetMutable(nil, get)
+	if err != nil || reply.Type != kadnet.MSG_MUTABLE_VALUE {
+		t.Fatalf("GET_MUTABLE failed: %v %v", reply, err)
+	}
+	if seq, value := reply.Args[2], reply.Args[3]; seq != "5" || value != hex.EncodeToString([]byte("current")) {
+		t.Fatalf("node should still hold seq 5 \"current\", got seq %s value %s", seq, value)
+	}
+}
+
+// TestStoreCannotWriteMutableSlot asserts STORE and GET only accept plain
+// keys, so a peer can neither plant a record in the mutable namespace that
+// would pin the slot against legitimate PUT_MUTABLEs nor read one back.
+func TestStoreCannotWriteMutableSlot(t *testing.T) {
+	n := startNode(t, node.NodeConfig{Addr: "127.0.0.1:31031"})
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	target := node.MutableTarget(priv.Public().(ed25519.PublicKey), n.ID.Len())
+	for _, key := range []string{node.MUTABLE_PREFIX + target.String(), node.MANIFEST_PREFIX + target.String(), "abcd"} {
+		store := kadnet.Message{Type: kadnet.MSG_STORE, Args: []string{n.ID.String(), key, hex.EncodeToString([]byte("planted"))}}
+		if _, err := n.HandleStore(nil, store); err == nil {
+			t.Fatalf("STORE under %q accepted", key)
+		}
+	}
+
+	rec := node.SignMutable(priv, 1, []byte("legit"))
+	put := kadnet.Message{Type: kadnet.MSG_PUT_MUTABLE, Args: []string{
+		n.ID.String(),
+		hex.EncodeToString(rec.PublicKey),
+		strconv.FormatUint(rec.Seq, 10),
+		hex.EncodeToString(rec.Value),
+		hex.EncodeToString(rec.Signature),
+	}}
+	reply, err := n.HandlePutMutable(nil, put)
+	if err != nil || reply.Type != kadnet.MSG_STORED {
+		t.Fatalf("PUT_MUTABLE after rejected STOREs failed: %v %v", reply, err)
+	}
+
+	// Nor can GET read the record back as a plain value
+	get := kadnet.Message{Type: kadnet.MSG_GET, Args: []string{n.ID.String(), node.MUTABLE_PREFIX + target.String()}}
+	if reply, err := n.HandleGet(nil, get); err == nil {
+		t.Fatalf("GET under the mutable prefix answered %s", reply.Type)
+	}
+}
@@ -25,6 +25,7 @@ func TestJoinNetwork(t *testing.T) {
 		ID: util.NewRandomID(), Addr: "127.0.0.1:10003", NewNet: makeMock, Peers: []string{a.Addr},
 	})
 	t.Logf("Created node c: ID=%s Addr=%s", c.ID.String(), c.Addr)
+	waitReady(t, time.Second, b, c)
 
 	defer a.Server.Close()
 	defer b.Server.Close()
@@ -72,7 +73,7 @@ func Test50NodesJoinNetwork(t *testing.T) {
 		nodes = append(nodes, n)
 	}
 
-	time.Sleep(200 * time.Millisecond)
+	waitReady(t, 5*time.Second, nodes...)
 
 	defer func() {
 		for _, n := range nodes {
@@ -41,14 +41,14 @@ func TestPackageLoss(t *testing.T) {
 		nodes = append(nodes, n)
 	}
 
-	// Allow join/RT exchange to settle a bit for large N
-	time.Sleep(300 * time.Millisecond)
+	waitReady(t, 5*time.Second, nodes...)
 
 	// Store
-	hash, err := bootstrap.Put([]byte("Hello"))
+	res, err := bootstrap.Put(context.Background(), []byte("Hello"))
 	if err != nil {
 		t.Fatalf("Put failed: %v", err)
 	}
+	hash := res.Key
 
 	// Drop some nodes (0% here so none will drop)
 	for i := 0; i < len(nodes); i++ {
@@ -64,7 +64,7 @@ func TestPackageLoss(t *testing.T) {
 	}
 
 	// If your IterativeFindValue needs context, use it; otherwise keep as-is.
-	val, _, err := bootstrap.IterativeFindValue(key, 800*time.Millisecond)
+	val, _, err := bootstrap.IterativeFindValue(context.Background(), key, 800*time.Millisecond)
 	if err != nil {
 		t.Fatalf("IterativeFindValue failed: %v", err)
 	}
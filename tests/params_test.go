@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestSmallReplicationFactor runs a network with K=2 and a replication
+// factor of 2 and asserts a Put reaches at most two nodes.
+func TestSmallReplicationFactor(t *testing.T) {
+	all := startNetwork(t, 41001, 7, func(_ int, cfg *node.NodeConfig) {
+		cfg.K, cfg.Alpha, cfg.Replication = 2, 1, 2
+	})
+	boot, nodes := all[0], all[1:]
+
+	want := kademlia.Params{K: 2, Alpha: 1, Replication: 2, IDBytes: util.IDBytes}
+	if got := boot.RoutingTable.Params(); got != want {
+		t.Fatalf("params = %s, want %s", got, want)
+	}
+	// With only six peers, the top buckets are the ones that fill up
+	perBucket := map[int]int{}
+	for _, c := range boot.RoutingTable.Contacts() {
+		perBucket[boot.ID.CommonPrefixLen(c.ID)]++
+	}
+	for b, count := range perBucket {
+		if count > 2 {
+			t.Fatalf("bucket %d holds %d contacts with K=2", b, count)
+		}
+	}
+
+	put, err := nodes[0].Put(context.Background(), []byte("replicated twice"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if len(put.Replicas) == 0 || len(put.Replicas) > 2 {
+		t.Fatalf("value stored on %d nodes, want 1 or 2", len(put.Replicas))
+	}
+}
+
+// TestWideIDNetwork runs two nodes with 256-bit IDs and asserts values are
+// keyed by SHA-256 and found across the network.
+func TestWideIDNetwork(t *testing.T) {
+	nodes := startNetwork(t, 41101, 2, func(_ int, cfg *node.NodeConfig) {
+		cfg.ID = util.NewRandomIDLen(util.WIDE_ID_BYTES)
+	})
+	a, b := nodes[0], nodes[1]
+
+	if got := a.RoutingTable.Params().IDBytes; got != util.WIDE_ID_BYTES {
+		t.Fatalf("ID width = %d bytes", got)
+	}
+	value := []byte("keyed by sha-256")
+	put, err := a.Put(context.Background(), value)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	sum := sha256.Sum256(value)
+	if !bytes.Equal(put.Key, sum[:]) {
+		t.Fatalf("key is not the SHA-256 of the value")
+	}
+	key, _ := util.IDFromBytes(put.Key)
+	res, err := b.Get(context.Background(), key, 0)
+	if err != nil || !bytes.Equal(res.Value, value) {
+		t.Fatalf("Get failed: err=%v value=%q", err, res.Value)
+	}
+}
+
+// TestMismatchedParamsRefused asserts nodes that differ in K or ID width
+// from a default node neither get answers from it nor enter its table.
+func TestMismatchedParamsRefused(t *testing.T) {
+	def := startNode(t, node.NodeConfig{Addr: "127.0.0.1:41201"})
+	small := startNode(t, node.NodeConfig{Addr: "127.0.0.1:41202", K: 8})
+	wide := startNode(t, node.NodeConfig{ID: util.NewRandomIDLen(util.WIDE_ID_BYTES), Addr: "127.0.0.1:41203"})
+
+	for _, pair := range [][2]*node.Node{{small, def}, {def, small}, {wide, def}, {def, wide}} {
+		from, to := pair[0], pair[1]
+		_, err := from.PingSync(context.Background(), to.Server.Addr(), 200*time.Millisecond)
+		if !errors.Is(err, node.ErrParamsMismatch) {
+			t.Fatalf("%s -> %s: expected parameters mismatch, got %v", from.RoutingTable.Params(), to.RoutingTable.Params(), err)
+		}
+	}
+	if len(def.RoutingTable.Contacts())+len(small.RoutingTable.Contacts())+len(wide.RoutingTable.Contacts()) != 0 {
+		t.Fatalf("mismatched nodes entered each other's routing tables")
+	}
+
+	// Nodes agreeing on non-default parameters talk normally
+	peer := startNode(t, node.NodeConfig{Addr: "127.0.0.1:41204", Peers: []string{small.Addr}, K: 8})
+	if _, err := peer.PingSync(context.Background(), small.Server.Addr(), 200*time.Millisecond); err != nil {
+		t.Fatalf("ping between matching nodes failed: %v", err)
+	}
+}
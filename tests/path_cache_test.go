@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// storeOnFurthest stores value only on the peer furthest from its key and
+// returns the peer closest to the key and the key.
+func storeOnFurthest(t *testing.T, peers []*node.Node, value []byte) (*node.Node, util.ID) {
+	t.Helper()
+	sum := sha1.Sum(value)
+	key, _ := util.ParseHexID(hex.EncodeToString(sum[:]))
+
+	// Only the furthest peer holds the value, so the closer ones miss first
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID.CalcDistance(&key).Less(peers[j].ID.CalcDistance(&key))
+	})
+	holder := peers[len(peers)-1]
+	store := kadnet.Message{
+		Type: kadnet.MSG_STORE,
+		Args: []string{holder.ID.String(), key.String(), hex.EncodeToString(value)},
+	}
+	if _, err := holder.HandleStore(nil, store); err != nil {
+		t.Fatalf("HandleStore failed: %v", err)
+	}
+	// Queries overlap, so slow the holder down to hear the misses first
+	holder.Server.(*kadnet.MockUDP).SetLatency(50 * time.Millisecond)
+	return peers[0], key
+}
+
+// TestFoundValueIsCachedOnPath asserts a successful lookup stores the value at
+// the closest node that answered NOT_FOUND.
+func TestFoundValueIsCachedOnPath(t *testing.T) {
+	nodes := startNetwork(t, 29001, 6, nil)
+	requester := nodes[5]
+	closest, key := storeOnFurthest(t, nodes[:5], []byte("Cached along the path 29001"))
+
+	if _, _, err := requester.IterativeFindValue(context.Background(), key, 800*time.Millisecond); err != nil {
+		t.Fatalf("IterativeFindValue failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	get := kadnet.Message{Type: kadnet.MSG_GET, Args: []string{requester.ID.String(), key.String()}}
+	if reply, _ := closest.HandleGet(nil, get); reply.Type != kadnet.MSG_VALUE {
+		t.Fatalf("closest node should have cached the value, got %s", reply.Type)
+	}
+}
+
+// TestPathCacheCanBeDisabled asserts DisablePathCache leaves the path untouched.
+func TestPathCacheCanBeDisabled(t *testing.T) {
+	nodes := startNetwork(t, 29011, 6, func(i int, cfg *node.NodeConfig) {
+		cfg.DisablePathCache = i == 5
+	})
+	requester := nodes[5]
+	closest, key := storeOnFurthest(t, nodes[:5], []byte("Cached along the path 29011"))
+
+	if _, _, err := requester.IterativeFindValue(context.Background(), key, 800*time.Millisecond); err != nil {
+		t.Fatalf("IterativeFindValue failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	get := kadnet.Message{Type: kadnet.MSG_GET, Args: []string{requester.ID.String(), key.String()}}
+	if reply, _ := closest.HandleGet(nil, get); reply.Type != kadnet.MSG_NOT_FOUND {
+		t.Fatalf("path cache disabled, closest node should not hold the value, got %s", reply.Type)
+	}
+}
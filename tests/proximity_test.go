@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestLookupPrefersLowRTT puts the only copy of a value on the one fast
+// node among slow ones at a similar distance, and asserts a lookup from a
+// node that has measured their RTTs asks the fast node first.
+func TestLookupPrefersLowRTT(t *testing.T) {
+	x := startNode(t, node.NodeConfig{Addr: "127.0.0.1:39001"})
+
+	value := []byte("served from the nearby data center")
+	sum := sha1.Sum(value)
+	key, _ := util.ParseHexID(hex.EncodeToString(sum[:]))
+
+	// Every peer differs from the key in its first bit: one distance class
+	far := key.FlipBit(0)
+	const peers = 6
+	var fast *node.Node
+	for i := 0; i < peers; i++ {
+		p := startNode(t, node.NodeConfig{
+			ID: util.RandomIDWithPrefix(far, 1), Addr: fmt.Sprintf("127.0.0.1:%d", 39010+i),
+			Peers: []string{x.Addr}, DisablePathCache: true,
+		})
+
+		latency := 100 * time.Millisecond
+		if i == peers-1 {
+			latency, fast = 2*time.Millisecond, p
+		}
+		p.Server.(*kadnet.MockUDP).SetLatency(latency)
+		if _, err := x.PingSync(context.Background(), p.Server.Addr(), time.Second); err != nil {
+			t.Fatalf("ping %s: %v", p.Addr, err)
+		}
+	}
+	msg := kadnet.Message{
+		Type: kadnet.MSG_STORE,
+		Args: []string{fast.ID.String(), key.String(), hex.EncodeToString(value)},
+	}
+	if _, err := fast.HandleStore(nil, msg); err != nil {
+		t.Fatalf("HandleStore failed: %v", err)
+	}
+
+	if rtt := x.SmoothedRTT(fast.Server.Addr()); rtt <= 0 || rtt > 50*time.Millisecond {
+		t.Fatalf("fast peer RTT not measured: %v", rtt)
+	}
+
+	start := time.Now()
+	res, err := x.FindValue(context.Background(), key, time.Second)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("FindValue failed: %v", err)
+	}
+	if res.From == nil || !res.From.ID.Equals(&fast.ID) {
+		t.Fatalf("value should come from the fast peer")
+	}
+	if elapsed > 50*time.Millisecond {
+		t.Fatalf("lookup waited on slow peers: %v", elapsed)
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestPutWriteQuorum asserts Put reports the replicas that acknowledged and
+// fails once an ack for the wrong key leaves it short of the quorum.
+func TestPutWriteQuorum(t *testing.T) {
+	nodes := startNetwork(t, 33001, 3, func(i int, cfg *node.NodeConfig) {
+		if i == 0 {
+			cfg.WriteQuorum = 2
+		}
+	})
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	res, err := a.Put(context.Background(), []byte("Stored on both peers"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if len(res.Replicas) != 2 || !hasReplica(res, b) || !hasReplica(res, c) {
+		t.Fatalf("expected B and C as replicas, got %v", res.Replicas)
+	}
+
+	// C now acknowledges a key it was not asked to store
+	c.Server.On(kadnet.MSG_STORE, func(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+		return &kadnet.Message{Type: kadnet.MSG_STORED, RPCID: msg.RPCID, Args: []string{c.ID.String(), util.NewRandomID().String()}}, nil
+	})
+	res, err = a.Put(context.Background(), []byte("Only B really stores this"))
+	if err == nil {
+		t.Fatalf("Put should fail below the write quorum")
+	}
+	if len(res.Replicas) != 1 || !hasReplica(res, b) {
+		t.Fatalf("expected only B as replica, got %v", res.Replicas)
+	}
+}
+
+// TestPutQuorumCappedAtReachable asserts a Put on a network smaller than
+// the write quorum succeeds once every reachable node acknowledged, and that
+// a lone node keeps the value to itself.
+func TestPutQuorumCappedAtReachable(t *testing.T) {
+	lone := startNode(t, node.NodeConfig{Addr: "127.0.0.1:33011"})
+	if res, err := lone.Put(context.Background(), []byte("Nobody else to ask")); err != nil || len(res.Replicas) != 0 {
+		t.Fatalf("Put on a lone node: %v, replicas %v", err, res.Replicas)
+	}
+
+	nodes := startNetwork(t, 33021, 2, func(i int, cfg *node.NodeConfig) {
+		if i == 0 {
+			cfg.WriteQuorum = 3
+		}
+	})
+	a, b := nodes[0], nodes[1]
+	res, err := a.Put(context.Background(), []byte("Only B can store this"))
+	if err != nil {
+		t.Fatalf("Put on a two-node network failed: %v", err)
+	}
+	if len(res.Replicas) != 1 || !hasReplica(res, b) {
+		t.Fatalf("expected B as replica, got %v", res.Replicas)
+	}
+}
+
+func hasReplica(res node.PutResult, n *node.Node) bool {
+	for _, r := range res.Replicas {
+		if r.ID.Equals(&n.ID) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestIDPrefixHelpers checks CommonPrefixLen and RandomIDWithPrefix around
+// byte boundaries.
+func TestIDPrefixHelpers(t *testing.T) {
+	var a, b util.ID
+	if got := a.CommonPrefixLen(&b); got != util.IDBytes*8 {
+		t.Fatalf("equal IDs: got %d", got)
+	}
+	b = a.FlipBit(0)
+	if got := a.CommonPrefixLen(&b); got != 0 {
+		t.Fatalf("first bit differs: got %d", got)
+	}
+	b = a.FlipBit(15)
+	if got := a.CommonPrefixLen(&b); got != 15 {
+		t.Fatalf("bit 15 differs: got %d", got)
+	}
+
+	prefix := util.NewRandomID()
+	for _, bits := range []int{0, 1, 7, 8, 9, 100, util.IDBytes * 8} {
+		for i := 0; i < 20; i++ {
+			id := util.RandomIDWithPrefix(prefix, bits)
+			if got := id.CommonPrefixLen(&prefix); got < bits {
+				t.Fatalf("RandomIDWithPrefix(%d) shares only %d bits", bits, got)
+			}
+		}
+	}
+}
+
+// TestStaleBucketIsRefreshed lets B's bucket holding A go stale and asserts B
+// looks up a random ID inside that bucket's range.
+func TestStaleBucketIsRefreshed(t *testing.T) {
+	a := startNode(t, node.NodeConfig{Addr: "127.0.0.1:35001"})
+
+	var mu sync.Mutex
+	var targets []util.ID
+	a.Server.On(kadnet.MSG_FIND_NODE, func(from *net.UDPAddr, msg kadnet.Message) (*kadnet.Message, error) {
+		if target, err := util.ParseHexID(msg.Args[1]); err == nil {
+			mu.Lock()
+			targets = append(targets, target)
+			mu.Unlock()
+		}
+		return a.HandleFindNode(from, msg)
+	})
+
+	b := startNode(t, node.NodeConfig{
+		Addr: "127.0.0.1:35002", Peers: []string{a.Addr}, RefreshInterval: 200 * time.Millisecond,
+	})
+
+	bucket := a.ID.CommonPrefixLen(&b.ID)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		for _, target := range targets {
+			if target.CommonPrefixLen(&b.ID) == bucket {
+				mu.Unlock()
+				return
+			}
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("B never refreshed bucket %d", bucket)
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// farContact returns a contact in bucket 0 of a table whose own ID is zero
+func farContact(i int) kademlia.Contact {
+	var self util.ID
+	id := util.RandomIDWithPrefix(self.FlipBit(0), 1)
+	addr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf("127.0.0.1:%d", 36000+i))
+	return kademlia.NewContact(&id, addr)
+}
+
+// TestReplacementCachePromotion fills a bucket, overflows it into the
+// replacement cache and asserts removals promote the newest candidates.
+func TestReplacementCachePromotion(t *testing.T) {
+	var self util.ID
+	addr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:36000")
+	rt := kademlia.CreateRoutingTable(kademlia.NewContact(&self, addr))
+
+	var members []kademlia.Contact
+	for i := 1; i <= kademlia.K; i++ {
+		c := farContact(i)
+		if evict := rt.AddContact(c); evict != nil {
+			t.Fatalf("bucket full after %d contacts", i-1)
+		}
+		members = append(members, c)
+	}
+
+	// Overflow by more than the cache holds: only the newest are kept
+	var extra []kademlia.Contact
+	for i := 0; i < kademlia.REPLACEMENT_CACHE_SIZE+5; i++ {
+		c := farContact(100 + i)
+		if evict := rt.AddContact(c); evict == nil || !evict.ID.Equals(members[0].ID) {
+			t.Fatalf("full bucket should offer its least recently seen member, got %v", evict)
+		}
+		extra = append(extra, c)
+	}
+	cache := rt.Replacements(members[0].ID)
+	if len(cache) != kademlia.REPLACEMENT_CACHE_SIZE {
+		t.Fatalf("cache holds %d, want %d", len(cache), kademlia.REPLACEMENT_CACHE_SIZE)
+	}
+	newest := extra[len(extra)-1]
+	if !cache[0].ID.Equals(newest.ID) {
+		t.Fatalf("cache should start with the newest candidate")
+	}
+
+	// A member already in the full bucket is refreshed, not cached
+	if evict := rt.AddContact(members[3]); evict != nil {
+		t.Fatalf("re-adding a member should not ask for an eviction")
+	}
+
+	rt.RemoveContact(members[0])
+	if !hasContact(rt.Contacts(), *newest.ID) || hasContact(rt.Contacts(), *members[0].ID) {
+		t.Fatalf("removing a member should promote the newest replacement")
+	}
+	if got := len(rt.Replacements(members[0].ID)); got != kademlia.REPLACEMENT_CACHE_SIZE-1 {
+		t.Fatalf("promoted candidate should leave the cache, %d left", got)
+	}
+	// The oldest candidates fell out of the cache and are never promoted
+	for _, m := range members[1:] {
+		rt.RemoveContact(m)
+	}
+	for _, c := range extra[:5] {
+		if hasContact(rt.Contacts(), *c.ID) {
+			t.Fatalf("candidate evicted from the cache was promoted")
+		}
+	}
+	if got := len(rt.Contacts()); got != kademlia.REPLACEMENT_CACHE_SIZE {
+		t.Fatalf("expected every cached candidate promoted, table holds %d", got)
+	}
+}
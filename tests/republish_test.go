@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestHolderReplicatesKey stores a value on A only and asserts A pushes it to B
+// once the replicate interval has passed.
+func TestHolderReplicatesKey(t *testing.T) {
+	nodes := startNetwork(t, 24001, 2, func(i int, cfg *node.NodeConfig) {
+		if i == 0 {
+			cfg.ReplicateInterval = 200 * time.Millisecond
+		}
+	})
+	a, b := nodes[0], nodes[1]
+
+	key := util.NewRandomID()
+	store := kadnet.Message{
+		Type: kadnet.MSG_STORE,
+		Args: []string{b.ID.String(), key.String(), hex.EncodeToString([]byte("Held by A")), "60"},
+	}
+	if _, err := a.HandleStore(nil, store); err != nil {
+		t.Fatalf("HandleStore failed: %v", err)
+	}
+
+	get := kadnet.Message{Type: kadnet.MSG_GET, Args: []string{a.ID.String(), key.String()}}
+	if reply, _ := b.HandleGet(nil, get); reply.Type != kadnet.MSG_NOT_FOUND {
+		t.Fatalf("B should not hold the key before replication, got %s", reply.Type)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if reply, _ := b.HandleGet(nil, get); reply.Type != kadnet.MSG_VALUE {
+		t.Fatalf("B should hold the key after replication, got %s", reply.Type)
+	}
+}
+
+// TestPublisherRepublishesKey asserts the original publisher keeps a value
+// alive on other nodes past its TTL by republishing it.
+func TestPublisherRepublishesKey(t *testing.T) {
+	nodes := startNetwork(t, 24011, 2, func(_ int, cfg *node.NodeConfig) {
+		cfg.DefaultTTL, cfg.MaxTTL = 300*time.Millisecond, 300*time.Millisecond
+		cfg.SweepInterval, cfg.RepublishInterval = 50*time.Millisecond, 100*time.Millisecond
+	})
+	a, b := nodes[0], nodes[1]
+
+	res, err := a.Put(context.Background(), []byte("Republished by A"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	keyBytes := res.Key
+
+	time.Sleep(700 * time.Millisecond)
+	get := kadnet.Message{Type: kadnet.MSG_GET, Args: []string{a.ID.String(), hex.EncodeToString(keyBytes)}}
+	if reply, _ := b.HandleGet(nil, get); reply.Type != kadnet.MSG_VALUE {
+		t.Fatalf("B should still hold the republished key, got %s", reply.Type)
+	}
+}
+
+// TestHolderSkipsRecentlyStoredKey keeps re-sending a STORE to A and asserts
+// A does not replicate the key while someone else keeps it fresh.
+func TestHolderSkipsRecentlyStoredKey(t *testing.T) {
+	nodes := startNetwork(t, 24021, 2, func(i int, cfg *node.NodeConfig) {
+		if i == 0 {
+			cfg.ReplicateInterval = 300 * time.Millisecond
+		}
+	})
+	a, b := nodes[0], nodes[1]
+
+	key := util.NewRandomID()
+	store := kadnet.Message{
+		Type: kadnet.MSG_STORE,
+		Args: []string{b.ID.String(), key.String(), hex.EncodeToString([]byte("Kept fresh for A")), "60"},
+	}
+	for i := 0; i < 8; i++ {
+		if _, err := a.HandleStore(nil, store); err != nil {
+			t.Fatalf("HandleStore failed: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	get := kadnet.Message{Type: kadnet.MSG_GET, Args: []string{a.ID.String(), key.String()}}
+	if reply, _ := b.HandleGet(nil, get); reply.Type != kadnet.MSG_NOT_FOUND {
+		t.Fatalf("A should not replicate a key stored within the interval, B got %s", reply.Type)
+	}
+}
+
+// TestRepublishBeforeExpiry keeps the default ratio of a republish interval
+// equal to the TTL and asserts the publisher refreshes its key, locally and
+// on B, before it expires.
+func TestRepublishBeforeExpiry(t *testing.T) {
+	nodes := startNetwork(t, 24031, 2, func(_ int, cfg *node.NodeConfig) {
+		cfg.DefaultTTL, cfg.MaxTTL = 400*time.Millisecond, 400*time.Millisecond
+		cfg.RepublishInterval, cfg.SweepInterval = 400*time.Millisecond, 50*time.Millisecond
+	})
+	a, b := nodes[0], nodes[1]
+	if node.DEFAULT_TTL != node.REPUBLISH_INTERVAL {
+		t.Fatalf("test assumes the default TTL equals the republish interval")
+	}
+
+	res, err := a.Put(context.Background(), []byte("Outlives its first TTL"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	time.Sleep(time.Second)
+	get := kadnet.Message{Type: kadnet.MSG_GET, Args: []string{a.ID.String(), hex.EncodeToString(res.Key)}}
+	for _, n := range []*node.Node{a, b} {
+		if reply, _ := n.HandleGet(nil, get); reply.Type != kadnet.MSG_VALUE {
+			t.Fatalf("%s should still hold the key after two TTLs, got %s", n.Addr, reply.Type)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+)
+
+// TestAdaptiveTimeoutFollowsRTT asserts the per-peer timeout tracks measured
+// round trips, fails fast on a peer that stops answering and backs off.
+func TestAdaptiveTimeoutFollowsRTT(t *testing.T) {
+	x := startNode(t, node.NodeConfig{Addr: "127.0.0.1:40001", MinRTO: 50 * time.Millisecond})
+	p := startNode(t, node.NodeConfig{Addr: "127.0.0.1:40002"})
+	addr := p.Server.Addr()
+	mock := p.Server.(*kadnet.MockUDP)
+	// p is never added to x's routing table; the estimate does not need it
+
+	if got := x.RTO(addr); got != node.INITIAL_RTO {
+		t.Fatalf("unmeasured peer RTO = %v, want %v", got, node.INITIAL_RTO)
+	}
+
+	// A slow link must not time out under the initial estimate
+	mock.SetLatency(150 * time.Millisecond)
+	if _, err := x.PingSync(context.Background(), addr, node.ADAPTIVE_TIMEOUT); err != nil {
+		t.Fatalf("ping over slow link failed: %v", err)
+	}
+	if got := x.RTO(addr); got < 150*time.Millisecond || got >= node.INITIAL_RTO {
+		t.Fatalf("RTO after a 150ms sample = %v", got)
+	}
+
+	// On a fast link the estimate converges down to the floor
+	mock.SetLatency(time.Millisecond)
+	for i := 0; i < 30; i++ {
+		if _, err := x.PingSync(context.Background(), addr, node.ADAPTIVE_TIMEOUT); err != nil {
+			t.Fatalf("ping failed: %v", err)
+		}
+	}
+	if got := x.RTO(addr); got != 50*time.Millisecond {
+		t.Fatalf("RTO on fast link = %v, want the 50ms floor", got)
+	}
+
+	// A peer that stops answering costs about one RTO, not the old 800ms
+	mock.SetLatency(time.Hour)
+	start := time.Now()
+	if _, err := x.PingSync(context.Background(), addr, node.ADAPTIVE_TIMEOUT); err == nil {
+		t.Fatalf("ping to unresponsive peer should time out")
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("timeout took %v", elapsed)
+	}
+	if got := x.RTO(addr); got != 100*time.Millisecond {
+		t.Fatalf("RTO after a timeout = %v, want it doubled to 100ms", got)
+	}
+}
+
+// TestRTOCeiling asserts MaxRTO caps the timeout for unmeasured peers.
+func TestRTOCeiling(t *testing.T) {
+	x := startNode(t, node.NodeConfig{Addr: "127.0.0.1:40011", MaxRTO: 300 * time.Millisecond})
+
+	if got := x.RTO(x.Server.Addr()); got != 300*time.Millisecond {
+		t.Fatalf("RTO = %v, want the 300ms ceiling", got)
+	}
+}
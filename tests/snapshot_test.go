@@ -0,0 +1,38 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestWarmRestartWithoutBootstrap joins B and C through A, stops B and A,
+// then restarts B without peers and asserts it reseeds C from its snapshot.
+func TestWarmRestartWithoutBootstrap(t *testing.T) {
+	dir := t.TempDir()
+	idB := util.NewRandomID()
+
+	nodes := startNetwork(t, 26001, 3, func(i int, cfg *node.NodeConfig) {
+		if i == 1 {
+			cfg.ID, cfg.DataDir = idB, dir
+		}
+	})
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	// C's join lookup queries B, so B learns C before the snapshot is taken
+	if !hasContact(b.RoutingTable.Contacts(), c.ID) {
+		t.Fatalf("B should know C before restarting")
+	}
+
+	// Snapshot is written on shutdown; the bootstrap node goes away for good
+	b.Shutdown(context.Background())
+	a.Shutdown(context.Background())
+
+	// Without peers B is ready once it has reseeded from its snapshot
+	b = startNode(t, node.NodeConfig{ID: idB, Addr: "127.0.0.1:26002", DataDir: dir})
+	if !hasContact(b.RoutingTable.Contacts(), c.ID) {
+		t.Fatalf("restarted B should have reseeded C from its snapshot")
+	}
+}
@@ -0,0 +1,177 @@
+package tests
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/storage"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestFileStoreReopen writes, overwrites and deletes records, then reopens the
+// log and asserts the index is rebuilt with only the latest live records.
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := storage.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+
+	expires := time.Now().Add(time.Hour)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("store op failed: %v", err)
+		}
+	}
+	must(s.Put("a", storage.Record{Value: []byte("first"), ExpiresAt: expires}))
+	must(s.Put("a", storage.Record{Value: []byte("second"), ExpiresAt: expires}))
+	must(s.Put("b", storage.Record{Value: []byte("gone"), ExpiresAt: expires}))
+	must(s.Delete("b"))
+	must(s.Close())
+
+	// Simulate a crash mid-append by leaving garbage at the end of the log
+	f, err := os.OpenFile(filepath.Join(dir, storage.LOG_FILE), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	f.Write([]byte{0xde, 0xad})
+	f.Close()
+
+	s, err = storage.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer s.Close()
+
+	rec, ok, err := s.Get("a")
+	if err != nil || !ok {
+		t.Fatalf("expected key a after reopen: ok=%v err=%v", ok, err)
+	}
+	if string(rec.Value) != "second" {
+		t.Fatalf("value mismatch: got %q want %q", rec.Value, "second")
+	}
+	if rec.ExpiresAt.UnixNano() != expires.UnixNano() {
+		t.Fatalf("expiry not preserved: got %v want %v", rec.ExpiresAt, expires)
+	}
+	if _, ok, _ := s.Get("b"); ok {
+		t.Fatalf("deleted key b should not survive reopen")
+	}
+	if st := s.Stats(); st.Keys != 1 || st.Bytes != int64(len("second")) {
+		t.Fatalf("unexpected stats after reopen: %+v", st)
+	}
+}
+
+// TestNodeServesValuesAfterRestart stores a value on a file-backed node,
+// restarts it on the same data directory and asserts HandleGet still serves it.
+func TestNodeServesValuesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	id := util.NewRandomID()
+
+	a := startNode(t, node.NodeConfig{ID: id, Addr: "127.0.0.1:25001", DataDir: dir})
+	key := util.NewRandomID()
+	value := []byte("Survives restarts")
+	store := kadnet.Message{
+		Type: kadnet.MSG_STORE,
+		Args: []string{a.ID.String(), key.String(), hex.EncodeToString(value)},
+	}
+	if _, err := a.HandleStore(nil, store); err != nil {
+		t.Fatalf("HandleStore failed: %v", err)
+	}
+	if err := a.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	a = startNode(t, node.NodeConfig{ID: id, Addr: "127.0.0.1:25001", DataDir: dir})
+
+	get := kadnet.Message{Type: kadnet.MSG_GET, Args: []string{a.ID.String(), key.String()}}
+	reply, err := a.HandleGet(nil, get)
+	if err != nil {
+		t.Fatalf("HandleGet failed: %v", err)
+	}
+	if reply.Type != kadnet.MSG_VALUE || reply.Args[2] != hex.EncodeToString(value) {
+		t.Fatalf("expected stored value after restart, got %s %v", reply.Type, reply.Args)
+	}
+}
+
+// TestFileStoreCompacts overwrites one key until the log is compacted and
+// asserts the log shrinks while the latest value survives a reopen.
+func TestFileStoreCompacts(t *testing.T) {
+	dir := t.TempDir()
+	s, err := storage.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+
+	value := make([]byte, 8<<10)
+	for i := 0; i < 250; i++ {
+		value[0] = byte(i)
+		if err := s.Put("k", storage.Record{Value: value, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatalf("Put %d failed: %v", i, err)
+		}
+	}
+	s.Close()
+
+	info, err := os.Stat(filepath.Join(dir, storage.LOG_FILE))
+	if err != nil {
+		t.Fatalf("stat log: %v", err)
+	}
+	if info.Size() > storage.COMPACT_THRESHOLD {
+		t.Fatalf("log was not compacted: %d bytes", info.Size())
+	}
+
+	s, err = storage.OpenFileStore(dir)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer s.Close()
+	rec, ok, _ := s.Get("k")
+	if !ok || rec.Value[0] != byte(249) {
+		t.Fatalf("latest value lost after compaction: ok=%v", ok)
+	}
+}
+
+// TestStoreTouchSeesLatestRecord asserts Touch hands fn the record as
+// currently stored and keeps the value while updating its times.
+func TestStoreTouchSeesLatestRecord(t *testing.T) {
+	fs, err := storage.OpenFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenFileStore failed: %v", err)
+	}
+	defer fs.Close()
+
+	for name, s := range map[string]storage.Store{"memory": storage.NewMemoryStore(), "file": fs} {
+		now := time.Now()
+		s.Put("k", storage.Record{Value: []byte("old"), ExpiresAt: now.Add(time.Minute)})
+		// A STORE arriving after a snapshot was taken
+		s.Put("k", storage.Record{Value: []byte("new"), ExpiresAt: now.Add(time.Hour)})
+
+		rec, ok, err := s.Touch("k", func(rec *storage.Record) bool {
+			rec.ReplicatedAt = now
+			rec.Value = []byte("ignored")
+			return true
+		})
+		if err != nil || !ok {
+			t.Fatalf("%s: Touch failed: ok=%v err=%v", name, ok, err)
+		}
+		got, _, _ := s.Get("k")
+		for _, r := range []storage.Record{rec, got} {
+			if string(r.Value) != "new" || !r.ExpiresAt.Equal(now.Add(time.Hour)) || !r.ReplicatedAt.Equal(now) {
+				t.Fatalf("%s: unexpected record after Touch: %q %v %v", name, r.Value, r.ExpiresAt, r.ReplicatedAt)
+			}
+		}
+
+		if _, ok, _ := s.Touch("k", func(*storage.Record) bool { return false }); ok {
+			t.Fatalf("%s: Touch applied although fn declined", name)
+		}
+		if _, ok, _ := s.Touch("missing", func(*storage.Record) bool { return true }); ok {
+			t.Fatalf("%s: Touch applied to a missing key", name)
+		}
+	}
+}
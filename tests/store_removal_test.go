@@ -1,6 +1,8 @@
 package tests
 
 import (
+	"context"
+	"crypto/sha1"
 	"encoding/hex"
 	"net"
 	"testing"
@@ -23,10 +25,11 @@ func TestStoreAndRetrieve(t *testing.T) {
 		ID: util.NewRandomID(), Addr: "127.0.0.1:22002", NewNet: makeMock, Peers: []string{a.Addr},
 	})
 	defer b.Server.Close()
-	time.Sleep(100 * time.Millisecond)
+	waitReady(t, time.Second, b)
 
-	key := util.NewRandomID()
 	value := []byte("Hello, StoreAndRetrieve")
+	sum := sha1.Sum(value)
+	key, _ := util.ParseHexID(hex.EncodeToString(sum[:]))
 	msg := kadnet.Message{
 		Type: kadnet.MSG_STORE,
 		Args: []string{a.ID.String(), key.String(), hex.EncodeToString(value)},
@@ -36,7 +39,7 @@ func TestStoreAndRetrieve(t *testing.T) {
 	}
 
 	time.Sleep(100 * time.Millisecond)
-	val, _, err := b.IterativeFindValue(key, 800*time.Millisecond)
+	val, _, err := b.IterativeFindValue(context.Background(), key, 800*time.Millisecond)
 	if err != nil || val == nil {
 		t.Fatalf("B failed to find value: err=%v val=%v", err, val)
 	}
@@ -57,22 +60,24 @@ func TestRemoveNodeWithStoredValue(t *testing.T) {
 		ID: util.NewRandomID(), Addr: "127.0.0.1:21002", NewNet: makeMock, Peers: []string{a.Addr},
 	})
 	defer b.Server.Close()
+	waitReady(t, time.Second, b)
 
+	// Stored on A only: a Put would also leave a replica on B, which B
+	// then finds in its own store
 	value := []byte("Goodbye, Node A")
-	keyBytes, err := a.Put(value)
-
-	if err != nil {
-		t.Fatalf("Put failed: %v", err)
+	sum := sha1.Sum(value)
+	key, _ := util.ParseHexID(hex.EncodeToString(sum[:]))
+	msg := kadnet.Message{
+		Type: kadnet.MSG_STORE,
+		Args: []string{a.ID.String(), key.String(), hex.EncodeToString(value)},
 	}
-
-	key, err := util.ParseHexID(hex.EncodeToString(keyBytes[:]))
-	if err != nil {
-		t.Fatalf("Failed to parse hash: %v", err)
+	if _, err := a.HandleStore(nil, msg); err != nil {
+		t.Fatalf("HandleStore failed: %v", err)
 	}
 
 	// ensure B can find the value initially
 	time.Sleep(50 * time.Millisecond)
-	if val, _, err := b.IterativeFindValue(key, 800*time.Millisecond); err != nil || val == nil {
+	if val, _, err := b.IterativeFindValue(context.Background(), key, 800*time.Millisecond); err != nil || val == nil {
 		t.Fatalf("Expected B to find value before removal, err=%v val=%v", err, val)
 	}
 
@@ -85,7 +90,7 @@ func TestRemoveNodeWithStoredValue(t *testing.T) {
 	b.RoutingTable.RemoveContact(c)
 
 	time.Sleep(50 * time.Millisecond)
-	if _, _, err := b.IterativeFindValue(key, 200*time.Millisecond); err == nil {
+	if _, _, err := b.IterativeFindValue(context.Background(), key, 200*time.Millisecond); err == nil {
 		t.Fatalf("Expected B to NOT find value after A removal, but lookup succeeded")
 	}
 }
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestTraceRecordsEveryQuery asserts a traced lookup records each query
+// with its round and outcome, the contacts replies taught it and how close
+// it got, and that a query still in flight at the end shows as abandoned.
+func TestTraceRecordsEveryQuery(t *testing.T) {
+	target := util.NewIDFromSeed("lookup-target")
+
+	hung := startHung(t, node.NodeConfig{ID: target.FlipBit(0), Addr: "127.0.0.1:44011", Replication: 3})
+	live := startNetwork(t, 44001, 6, nearTarget(target))
+	searcher := startNode(t, node.NodeConfig{Addr: "127.0.0.1:44022", Replication: 3})
+	introduce(searcher, live[0], hung)
+
+	trace := &node.Trace{}
+	contacts := searcher.IterativeFindNode(node.WithTrace(context.Background(), trace), target, 2*time.Second)
+
+	lookups := trace.Lookups()
+	if len(lookups) != 1 {
+		t.Fatalf("want 1 traced lookup, got %d", len(lookups))
+	}
+	lt := lookups[0]
+	if lt.Kind != kadnet.MSG_FIND_NODE || lt.Target != target.String() || lt.Result != "converged" {
+		t.Fatalf("unexpected lookup header: %s %s %s", lt.Kind, lt.Target, lt.Result)
+	}
+	if want := kademlia.EncodeContactsForArgs(contacts); len(lt.Contacts) != len(want) || lt.Contacts[0] != want[0] {
+		t.Fatalf("traced contacts %v, lookup returned %v", lt.Contacts, want)
+	}
+
+	learned, maxRound, closest := 0, 0, -1
+	for _, q := range lt.Queries {
+		switch {
+		case q.ID == hung.ID.String():
+			if q.Outcome != node.TRACE_ABANDONED || q.Round != 1 {
+				t.Fatalf("hung query recorded as round %d %s", q.Round, q.Outcome)
+			}
+			continue
+		case q.Outcome != node.TRACE_NODES:
+			t.Fatalf("query to %s ended %s: %s", q.Addr, q.Outcome, q.Error)
+		case q.RTT <= 0:
+			t.Fatalf("query to %s has no RTT", q.Addr)
+		case q.Closest < closest:
+			t.Fatalf("closest prefix went from %d back to %d", closest, q.Closest)
+		}
+		learned += len(q.Learned)
+		maxRound = max(maxRound, q.Round)
+		closest = q.Closest
+	}
+	// The searcher only knew one live node, so the rest came from replies
+	if learned == 0 || maxRound < 2 {
+		t.Fatalf("want contacts learned over several rounds, got %d over %d", learned, maxRound)
+	}
+}
+
+// TestTraceRecordsFailures asserts a FIND_VALUE query to a contact nobody
+// answers for is recorded as timed out, with its error.
+func TestTraceRecordsFailures(t *testing.T) {
+	target := util.NewIDFromSeed("lookup-target")
+
+	ghostID := util.RandomIDWithPrefix(target, 40)
+	ghostAddr, _ := net.ResolveUDPAddr("udp", "127.0.0.1:44031")
+	live := startNetwork(t, 44001, 6, nearTarget(target))
+	searcher := startNode(t, node.NodeConfig{Addr: "127.0.0.1:44023", Replication: 3})
+	introduce(searcher, live[0])
+	searcher.AddContact(kademlia.NewContact(&ghostID, ghostAddr))
+
+	trace := &node.Trace{}
+	if _, _, err := searcher.IterativeFindValue(node.WithTrace(context.Background(), trace), target, time.Second); err == nil {
+		t.Fatal("found a value nobody stored")
+	}
+
+	lt := trace.Lookups()[0]
+	if lt.Kind != kadnet.MSG_FIND_VALUE {
+		t.Fatalf("want a FIND_VALUE trace, got %s", lt.Kind)
+	}
+	for _, q := range lt.Queries {
+		if q.ID == ghostID.String() {
+			if q.Outcome != node.TRACE_TIMEOUT || q.Error == "" {
+				t.Fatalf("ghost query recorded as %s (%q)", q.Outcome, q.Error)
+			}
+			return
+		}
+	}
+	t.Fatalf("ghost query missing from %+v", lt.Queries)
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/t0sic/D7024E-Kademlia/internal/kademlia"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// treeContacts returns n contacts whose IDs start with bit first, sorted
+// furthest from the zero ID first
+func treeContacts(n int, first bool) []kademlia.Contact {
+	var zero util.ID
+	prefix := zero
+	if first {
+		prefix = zero.FlipBit(0)
+	}
+	var contacts []kademlia.Contact
+	for i := 0; i < n; i++ {
+		id := util.RandomIDWithPrefix(prefix, 1)
+		addr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf("127.0.0.1:%d", 42000+len(contacts)))
+		contacts = append(contacts, kademlia.NewContact(&id, addr))
+	}
+	sort.Slice(contacts, func(i, j int) bool { return contacts[j].ID.Less(contacts[i].ID) })
+	return contacts
+}
+
+// TestTreeTableSplitsOwnBucket asserts only the bucket covering our own ID
+// splits, so the far half of the ID space keeps K contacts.
+func TestTreeTableSplitsOwnBucket(t *testing.T) {
+	var self util.ID
+	me := kademlia.NewContact(&self, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 42000})
+	params := kademlia.Params{K: 4, Alpha: 1, Replication: 4, IDBytes: util.IDBytes}
+	tree := kademlia.CreateTreeTable(me, params, false)
+
+	near, far := treeContacts(4, false), treeContacts(6, true)
+	for _, c := range append(near, far[:4]...) {
+		if evict := tree.AddContact(c); evict != nil {
+			t.Fatalf("bucket full before K contacts per half")
+		}
+	}
+	if tree.Depth() == 0 {
+		t.Fatalf("root bucket holding our ID should have split")
+	}
+	if evict := tree.AddContact(far[4]); evict == nil {
+		t.Fatalf("far bucket without our ID should not split")
+	}
+	if got := len(tree.Contacts()); got != 8 {
+		t.Fatalf("table holds %d contacts, want 8", got)
+	}
+	if got := len(tree.Replacements(far[4].ID)); got != 1 {
+		t.Fatalf("overflow should be cached as a replacement, cache holds %d", got)
+	}
+}
+
+// TestTreeTableRelaxedSplit asserts relaxed splitting keeps every contact
+// that is among our K closest, even in a bucket away from our ID.
+func TestTreeTableRelaxedSplit(t *testing.T) {
+	var self util.ID
+	me := kademlia.NewContact(&self, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 42000})
+	params := kademlia.Params{K: 4, Alpha: 1, Replication: 4, IDBytes: util.IDBytes}
+	strict := kademlia.CreateTreeTable(me, params, false)
+	relaxed := kademlia.CreateTreeTable(me, params, true)
+
+	// Only the far half is populated, each contact closer than the last
+	far := treeContacts(8, true)
+	for _, c := range far {
+		strict.AddContact(c)
+		relaxed.AddContact(c)
+	}
+	if got := len(strict.Contacts()); got != 4 {
+		t.Fatalf("strict tree holds %d, want K=4", got)
+	}
+	if got := len(relaxed.Contacts()); got != 8 {
+		t.Fatalf("relaxed tree holds %d, want all 8", got)
+	}
+	closest := relaxed.FindClosestContacts(&self, 4)
+	for i, c := range closest {
+		if !c.ID.Equals(far[len(far)-1-i].ID) {
+			t.Fatalf("closest contact %d is not the %d-th closest added", i, i)
+		}
+	}
+}
+
+// lookupCost builds a network with the given table layout and reports the
+// average lookup hops and RPCs for a fixed set of targets, and how many
+// lookups missed the node actually closest to their target.
+func lookupCost(t *testing.T, base int, layout string, relaxed bool) (hops, queried float64, missed int) {
+	nodes := startNetwork(t, base, 120, func(i int, cfg *node.NodeConfig) {
+		cfg.ID = util.NewIDFromSeed(fmt.Sprintf("hops-%d", i))
+		cfg.K, cfg.Alpha, cfg.Replication = 2, 1, 2
+		cfg.TableLayout, cfg.RelaxedSplit = layout, relaxed
+	})
+
+	lookups := 0
+	for i, n := range nodes {
+		for j := 0; j < 5; j++ {
+			target := util.NewIDFromSeed(fmt.Sprintf("target-%d-%d", i, j))
+			res := n.FindNode(context.Background(), target, 0)
+			hops += float64(res.Hops)
+			queried += float64(res.Queried)
+			lookups++
+
+			var best *node.Node
+			for _, other := range nodes {
+				if other != n && (best == nil || other.ID.CalcDistance(&target).Less(best.ID.CalcDistance(&target))) {
+					best = other
+				}
+			}
+			if len(res.Contacts) == 0 || !res.Contacts[0].ID.Equals(&best.ID) {
+				missed++
+			}
+		}
+	}
+	return hops / float64(lookups), queried / float64(lookups), missed
+}
+
+// LOOKUP_COST_SLACK is how much worse the tree may do than flat before the
+// test fails; which contacts each table keeps depends on the order replies
+// race in during the joins, so the two runs are never quite identical.
+const LOOKUP_COST_SLACK = 0.1
+
+// TestTreeTableLookupHops compares lookups over the flat layout with the
+// relaxed splitting tree on the same seeded membership.
+func TestTreeTableLookupHops(t *testing.T) {
+	flatHops, flatRPCs, flatMissed := lookupCost(t, 42100, kademlia.TABLE_FLAT, false)
+	treeHops, treeRPCs, treeMissed := lookupCost(t, 42500, kademlia.TABLE_TREE, true)
+	t.Logf("flat: %.2f hops, %.2f RPCs, %d missed", flatHops, flatRPCs, flatMissed)
+	t.Logf("tree: %.2f hops, %.2f RPCs, %d missed", treeHops, treeRPCs, treeMissed)
+
+	if float64(treeMissed) > float64(flatMissed)*(1+LOOKUP_COST_SLACK) {
+		t.Fatalf("tree lookups missed the closest node %d times, flat %d", treeMissed, flatMissed)
+	}
+	if treeHops > flatHops*(1+LOOKUP_COST_SLACK) {
+		t.Fatalf("tree lookups take %.2f hops, well above flat's %.2f", treeHops, flatHops)
+	}
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestStoredValueExpires stores a value with a TTL above the node's maximum and
+// asserts the value is clamped, served while fresh and gone once it expires.
+func TestStoredValueExpires(t *testing.T) {
+	a := startNode(t, node.NodeConfig{
+		Addr: "127.0.0.1:23001", MaxTTL: 300 * time.Millisecond, SweepInterval: 50 * time.Millisecond,
+	})
+
+	key := util.NewRandomID()
+	value := []byte("Short lived")
+	store := kadnet.Message{
+		Type: kadnet.MSG_STORE,
+		Args: []string{a.ID.String(), key.String(), hex.EncodeToString(value), "3600"},
+	}
+	if _, err := a.HandleStore(nil, store); err != nil {
+		t.Fatalf("HandleStore failed: %v", err)
+	}
+
+	get := kadnet.Message{Type: kadnet.MSG_GET, Args: []string{a.ID.String(), key.String()}}
+	reply, err := a.HandleGet(nil, get)
+	if err != nil {
+		t.Fatalf("HandleGet failed: %v", err)
+	}
+	if reply.Type != kadnet.MSG_VALUE {
+		t.Fatalf("expected VALUE before expiry, got %s", reply.Type)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	reply, err = a.HandleGet(nil, get)
+	if err != nil {
+		t.Fatalf("HandleGet failed: %v", err)
+	}
+	if reply.Type != kadnet.MSG_NOT_FOUND {
+		t.Fatalf("expected NOT_FOUND after max TTL, got %s", reply.Type)
+	}
+}
+
+// TestStoreRejectsBadTTL asserts a malformed TTL argument is refused.
+func TestStoreRejectsBadTTL(t *testing.T) {
+	a := startNode(t, node.NodeConfig{Addr: "127.0.0.1:23002"})
+
+	msg := kadnet.Message{
+		Type: kadnet.MSG_STORE,
+		Args: []string{a.ID.String(), util.NewRandomID().String(), hex.EncodeToString([]byte("x")), "soon"},
+	}
+	if _, err := a.HandleStore(nil, msg); err == nil {
+		t.Fatalf("expected HandleStore to reject a non-numeric TTL")
+	}
+}
@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"testing"
+	"time"
+
+	kadnet "github.com/t0sic/D7024E-Kademlia/internal/net"
+	"github.com/t0sic/D7024E-Kademlia/internal/node"
+	"github.com/t0sic/D7024E-Kademlia/internal/util"
+)
+
+// TestPoisonedValueIsRejected has the peer closest to a key serve bytes that
+// do not hash to it, while the furthest peer holds the real value. The lookup
+// must skip the poisoned reply, return the real value and name the liar.
+func TestPoisonedValueIsRejected(t *testing.T) {
+	nodes := startNetwork(t, 30001, 6, func(i int, cfg *node.NodeConfig) {
+		cfg.DisablePathCache = i == 5
+	})
+	peers, requester := nodes[:5], nodes[5]
+
+	value := []byte("The genuine article")
+	sum := sha1.Sum(value)
+	key, _ := util.ParseHexID(hex.EncodeToString(sum[:]))
+
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID.CalcDistance(&key).Less(peers[j].ID.CalcDistance(&key))
+	})
+	storeOn := func(n *node.Node, v []byte) {
+		msg := kadnet.Message{Type: kadnet.MSG_STORE, Args: []string{n.ID.String(), key.String(), hex.EncodeToString(v)}}
+		if _, err := n.HandleStore(nil, msg); err != nil {
+			t.Fatalf("HandleStore failed: %v", err)
+		}
+	}
+	poisoned, holder := peers[0], peers[len(peers)-1]
+	storeOn(poisoned, []byte("A forged replacement"))
+	storeOn(holder, value)
+	// Lookups stop at the first good value, so let the liar answer first
+	holder.Server.(*kadnet.MockUDP).SetLatency(100 * time.Millisecond)
+
+	res, err := requester.Get(context.Background(), key, 800*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if string(res.Value) != string(value) {
+		t.Fatalf("got %q, want %q", res.Value, value)
+	}
+	if res.From == nil || !res.From.ID.Equals(&holder.ID) {
+		t.Fatalf("value should come from the honest holder, got %v", res.From)
+	}
+	found := false
+	for _, c := range res.Rejected {
+		if c.ID.Equals(&poisoned.ID) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("poisoned peer %s not reported in %v", poisoned.Addr, res.Rejected)
+	}
+}